@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// BenchmarkPoint is the total-return (back-adjusted) close of a benchmark symbol.
+type BenchmarkPoint struct {
+	Date  string
+	Value float64
+}
+
+// validateBenchmark rejects a BenchmarkSymbol without any stored daily bars.
+func validateBenchmark(config SimConfig) error {
+	if config.BenchmarkSymbol == "" || config.BenchmarkSymbol == config.Symbol {
+		return nil
+	}
+	src := resolveMarket(config.BenchmarkSymbol)
+	var count int64
+	if err := DB.Table(src.TableDaily).Where("symbol = ?", src.Symbol).Limit(1).Count(&count).Error; err != nil || count == 0 {
+		return fmt.Errorf("unknown benchmark symbol: %s", config.BenchmarkSymbol)
+	}
+	return nil
+}
+
+// loadBenchmark reads the daily closes of symbol from the last one before startDate,
+// adjusted for its dividends and splits so the series is a total-return index.
+func loadBenchmark(symbol, startDate string) ([]BenchmarkPoint, error) {
+	src := resolveMarket(symbol)
+	var daily []Kline
+	var before Kline
+	if err := DB.Table(src.TableDaily).Where("symbol = ? AND timestamp < ?", src.Symbol, startDate).Order("timestamp desc").First(&before).Error; err == nil {
+		daily = append(daily, before)
+	}
+	var after []Kline
+	if err := barQuery(src.TableDaily, src.Symbol, startDate, "").Order("timestamp asc").Find(&after).Error; err != nil {
+		return nil, err
+	}
+	daily = append(daily, after...)
+	if len(daily) == 0 {
+		return nil, fmt.Errorf("no daily data for benchmark %s", symbol)
+	}
+
+	actions, err := loadCorporateActions(symbol)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]BenchmarkPoint, len(daily))
+	for i, k := range adjustKlines(daily, actions, "hfq") {
+		points[i] = BenchmarkPoint{Date: k.Timestamp[:10], Value: k.Close}
+	}
+	return points, nil
+}
+
+// benchmarkAt returns the last value of points dated on or before date (before date
+// when strict), 0 if there is none.
+func benchmarkAt(points []BenchmarkPoint, date string, strict bool) float64 {
+	i := sort.Search(len(points), func(i int) bool {
+		if strict {
+			return points[i].Date >= date
+		}
+		return points[i].Date > date
+	})
+	if i == 0 {
+		return 0
+	}
+	return points[i-1].Value
+}
+
+// benchmarkCurve fills in each day's BenchmarkEquity: capital put into the benchmark
+// at the close before the first day and held. Without a benchmark series the traded
+// symbol itself is held, dividends included.
+func (e *simEngine) benchmarkCurve(stats []DailyStat, capital float64) {
+	if len(e.benchmark) == 0 {
+		for i := range stats {
+			stats[i].BenchmarkEquity = RoundTo3(capital * stats[i].benchGrowth)
+		}
+		return
+	}
+	// Rebased on the close before the run, or the first close when the series starts later
+	ref := e.benchmark[0].Value
+	if len(stats) > 0 {
+		if v := benchmarkAt(e.benchmark, stats[0].Date, true); v > 0 {
+			ref = v
+		}
+	}
+	for i := range stats {
+		v := benchmarkAt(e.benchmark, stats[i].Date, false)
+		if v == 0 {
+			v = ref
+		}
+		stats[i].BenchmarkEquity = RoundTo3(capital * v / ref)
+	}
+}
+
+// benchmarkMetrics compares the daily strategy equity with the benchmark curve.
+func benchmarkMetrics(result *SimResult, stats []DailyStat, capital float64) {
+	if len(stats) == 0 || capital <= 0 {
+		return
+	}
+	final := stats[len(stats)-1].BenchmarkEquity
+	result.ExcessReturn = RoundTo3(result.PeriodReturn - (final/capital-1)*100)
+
+	var strategy, bench, excess []float64
+	prevS, prevB := capital, capital
+	for _, s := range stats {
+		if prevS <= 0 || prevB <= 0 {
+			break
+		}
+		rs, rb := s.Equity/prevS-1, s.BenchmarkEquity/prevB-1
+		strategy = append(strategy, rs)
+		bench = append(bench, rb)
+		excess = append(excess, rs-rb)
+		prevS, prevB = s.Equity, s.BenchmarkEquity
+	}
+	if len(excess) < 2 {
+		return
+	}
+
+	meanExcess := Mean(excess)
+	if sd := StdDev(excess, meanExcess); sd > 0 {
+		result.TrackingError = RoundTo3(sd * math.Sqrt(252) * 100)
+		result.InformationRatio = RoundTo3(meanExcess / sd * math.Sqrt(252))
+	}
+	meanS, meanB := Mean(strategy), Mean(bench)
+	var cov, varB float64
+	for i := range bench {
+		cov += (strategy[i] - meanS) * (bench[i] - meanB)
+		varB += (bench[i] - meanB) * (bench[i] - meanB)
+	}
+	if varB > 0 {
+		beta := cov / varB
+		result.Beta = RoundTo3(beta)
+		// Jensen's alpha with a zero risk-free rate
+		result.Alpha = RoundTo3((meanS - beta*meanB) * 252 * 100)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// boundPolicies lists the supported SimConfig.BoundPolicy values: what the run does
+// once the price leaves [LowerBound, UpperBound]. Orders outside the bounds never fill.
+var boundPolicies = map[string]bool{
+	"":            true, // Same as "pause"
+	"pause":       true, // Stop placing orders outside the bounds, resume on the old grid when back inside
+	"reenter":     true, // Like pause, but re-center the grid on the price where it comes back inside
+	"liquidate":   true, // Leaving either bound sells everything and ends the run
+	"stop_loss":   true, // Leaving the lower bound sells everything and ends the run; above the upper bound pauses
+	"take_profit": true, // Leaving the upper bound sells everything and ends the run; below the lower bound pauses
+}
+
+func validateBounds(config SimConfig) error {
+	if !boundPolicies[config.BoundPolicy] {
+		return fmt.Errorf("unknown bound policy: %s", config.BoundPolicy)
+	}
+	if config.LowerBound < 0 || config.UpperBound < 0 {
+		return fmt.Errorf("bounds must not be negative")
+	}
+	if config.LowerBound > 0 && config.UpperBound > 0 && config.LowerBound >= config.UpperBound {
+		return fmt.Errorf("lowerBound must be below upperBound")
+	}
+	if config.StopLossPct < 0 || config.TakeProfitPct < 0 {
+		return fmt.Errorf("stopLossPct and takeProfitPct must not be negative")
+	}
+	if (config.StopLossPct > 0 || config.TakeProfitPct > 0) && config.InitialCapital <= 0 && config.InitialShares <= 0 {
+		return fmt.Errorf("stopLossPct and takeProfitPct need initialCapital or initialShares to measure equity against")
+	}
+	return nil
+}
+
+// reenterer is implemented by strategies that can restart their grid at a given bar,
+// used by the "reenter" bound policy.
+type reenterer interface {
+	Reenter(k Kline)
+}
+
+// inBounds reports whether an order's price lies inside the configured bounds.
+func (e *simEngine) inBounds(o Order) bool {
+	if e.config.LowerBound > 0 && o.Price < e.config.LowerBound-0.00001 {
+		return false
+	}
+	if e.config.UpperBound > 0 && o.Price > e.config.UpperBound+0.00001 {
+		return false
+	}
+	return true
+}
+
+// checkExit applies the bound policy and the equity stops after the strategy has
+// traded bar k. An exit sells what can be sold now and the rest on later bars.
+func (e *simEngine) checkExit(k Kline) {
+	config := e.config
+	below := config.LowerBound > 0 && k.Low < config.LowerBound-0.00001
+	above := config.UpperBound > 0 && k.High > config.UpperBound+0.00001
+	if below || above {
+		e.result.OutOfRangeBars++
+	}
+
+	switch {
+	case below && (config.BoundPolicy == "liquidate" || config.BoundPolicy == "stop_loss"):
+		// A gap through the bound fills at the open, otherwise at the bound
+		e.exit(k, "lower_bound", math.Min(config.LowerBound, k.Open))
+		return
+	case above && (config.BoundPolicy == "liquidate" || config.BoundPolicy == "take_profit"):
+		e.exit(k, "upper_bound", math.Max(config.UpperBound, k.Open))
+		return
+	}
+
+	if config.BoundPolicy == "reenter" {
+		if below || above {
+			e.outOfRange = true
+		} else if e.outOfRange {
+			e.outOfRange = false
+			if s, ok := e.strategy.(reenterer); ok {
+				s.Reenter(k)
+			}
+		}
+	}
+
+	if config.StopLossPct <= 0 && config.TakeProfitPct <= 0 {
+		return
+	}
+	// Equity against the starting capital plus the base position at the previous close
+	start := config.InitialCapital + float64(config.InitialShares)*e.preClose
+	pnl := e.pf.Cash + e.pf.Holdings()*k.Close - start
+	switch {
+	case config.StopLossPct > 0 && pnl <= -start*config.StopLossPct/100:
+		e.exit(k, "stop_loss", k.Close)
+	case config.TakeProfitPct > 0 && pnl >= start*config.TakeProfitPct/100:
+		e.exit(k, "take_profit", k.Close)
+	}
+}
+
+// exit ends the strategy's run and starts liquidating the whole position.
+func (e *simEngine) exit(k Kline, reason string, price float64) {
+	e.stopped = true
+	e.result.ExitReason = reason
+	e.result.ExitTime = k.Timestamp
+	e.cancelResting()
+	e.liquidate(price)
+}
+
+// liquidate sells every share that may be sold in the current session at price.
+// Unsettled or limit-locked shares are left for the next bar.
+func (e *simEngine) liquidate(price float64) {
+	amount := math.Min(e.pf.Holdings(), e.lots.sellable(e.stat.Date))
+	if amount <= 0.0001 {
+		return
+	}
+	e.fill(Order{Side: "SELL", Price: price, Amount: amount, Reason: e.result.ExitReason})
+}
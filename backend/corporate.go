@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// CorporateAction is a dividend and/or split taking effect on ExDate.
+type CorporateAction struct {
+	Symbol       string  `gorm:"primaryKey" json:"symbol"`
+	ExDate       string  `gorm:"primaryKey" json:"exDate"` // YYYY-MM-DD
+	CashPerShare float64 `json:"cashPerShare"`             // Cash dividend per share held before the ex-date
+	SplitRatio   float64 `json:"splitRatio"`               // Shares after / before, e.g. 1.3 for 10送3 (0 or 1 = no split)
+}
+
+func (CorporateAction) TableName() string {
+	return "corporate_actions"
+}
+
+func (a CorporateAction) ratio() float64 {
+	if a.SplitRatio <= 0 {
+		return 1
+	}
+	return a.SplitRatio
+}
+
+// exRightsPrice moves a price from before the ex-date to the basis after it: the
+// dividend comes off and the split divides the rest. Grid levels and the lots bought
+// at them move through this one function so that they keep matching.
+func (a CorporateAction) exRightsPrice(price float64) float64 {
+	return RoundTo3((price - a.CashPerShare) / a.ratio())
+}
+
+// CorporateActionEvent is a corporate action as applied to the simulated account.
+type CorporateActionEvent struct {
+	Date         string  `json:"date"`
+	CashPerShare float64 `json:"cashPerShare"`
+	SplitRatio   float64 `json:"splitRatio"`
+	Shares       float64 `json:"shares"` // Holdings entitled to the action
+	Cash         float64 `json:"cash"`   // Dividend credited
+}
+
+func loadCorporateActions(symbol string) ([]CorporateAction, error) {
+	var actions []CorporateAction
+	err := DB.Where("symbol = ?", symbol).Order("ex_date asc").Find(&actions).Error
+	return actions, err
+}
+
+func RegisterCorporateActionRoutes(r *gin.Engine) {
+	// GET /api/corporate-actions?symbol=512890
+	r.GET("/api/corporate-actions", func(c *gin.Context) {
+		query := DB.Order("symbol asc, ex_date asc")
+		if symbol := c.Query("symbol"); symbol != "" {
+			query = query.Where("symbol = ?", symbol)
+		}
+		var actions []CorporateAction
+		if err := query.Find(&actions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": actions})
+	})
+
+	// POST /api/corporate-actions - Create or replace the action for (symbol, exDate)
+	r.POST("/api/corporate-actions", func(c *gin.Context) {
+		var action CorporateAction
+		if err := c.ShouldBindJSON(&action); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if action.Symbol == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "symbol is required"})
+			return
+		}
+		if _, err := time.Parse("2006-01-02", action.ExDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "exDate must be a date (YYYY-MM-DD)"})
+			return
+		}
+		if action.SplitRatio == 0 {
+			action.SplitRatio = 1 // A dividend without a split
+		}
+		if action.CashPerShare < 0 || action.SplitRatio < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cashPerShare must not be negative and splitRatio must be positive"})
+			return
+		}
+		if err := DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&action).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": action})
+	})
+
+	// DELETE /api/corporate-actions/:symbol/:exDate
+	r.DELETE("/api/corporate-actions/:symbol/:exDate", func(c *gin.Context) {
+		if err := DB.Delete(&CorporateAction{}, "symbol = ? AND ex_date = ?", c.Param("symbol"), c.Param("exDate")).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Corporate action removed"})
+	})
+}
+
+// adjustKlines returns a price-adjusted copy of sorted daily klines.
+// "qfq" (forward) keeps the latest prices and scales history down; "hfq" (backward)
+// keeps the first prices and scales everything after each ex-date up.
+func adjustKlines(klines []Kline, actions []CorporateAction, mode string) []Kline {
+	if len(klines) == 0 || len(actions) == 0 {
+		return klines
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].ExDate < actions[j].ExDate
+	})
+
+	// factors[i] is the cumulative ex-rights factor of every action on or before klines[i]
+	factors := make([]float64, len(klines))
+	cumulative := 1.0
+	next := 0
+	for i, k := range klines {
+		date := k.Timestamp[:10]
+		for next < len(actions) && actions[next].ExDate <= date {
+			a := actions[next]
+			next++
+			if i == 0 || a.ExDate <= klines[0].Timestamp[:10] {
+				continue // No close before the ex-date in this series
+			}
+			prevClose := klines[i-1].Close
+			if prevClose <= 0 {
+				continue
+			}
+			// Ex-rights reference price over the last close
+			cumulative *= (prevClose - a.CashPerShare) / a.ratio() / prevClose
+		}
+		factors[i] = cumulative
+	}
+
+	out := make([]Kline, len(klines))
+	for i, k := range klines {
+		f := cumulative / factors[i] // qfq: relative to the latest basis
+		if mode == "hfq" {
+			f = 1 / factors[i] // relative to the first basis
+		}
+		k.Open *= f
+		k.Close *= f
+		k.High *= f
+		k.Low *= f
+		out[i] = k
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestAdjustKlines(t *testing.T) {
+	bars := func(closes ...float64) []Kline {
+		var out []Kline
+		for i, c := range closes {
+			out = append(out, Kline{Timestamp: fmt.Sprintf("2024-01-%02d", i+1), Open: c, High: c, Low: c, Close: c})
+		}
+		return out
+	}
+	cases := []struct {
+		name    string
+		closes  []float64
+		actions []CorporateAction
+		mode    string
+		want    []float64
+	}{
+		// 1.0 off a close of 10: the ex-rights reference is 9
+		{"dividend qfq", []float64{10, 9}, []CorporateAction{{ExDate: "2024-01-02", CashPerShare: 1}}, "qfq", []float64{9, 9}},
+		{"dividend hfq", []float64{10, 9}, []CorporateAction{{ExDate: "2024-01-02", CashPerShare: 1}}, "hfq", []float64{10, 10}},
+		// 2-for-1 split off a close of 10: the reference is 5
+		{"split qfq", []float64{10, 5, 6}, []CorporateAction{{ExDate: "2024-01-02", SplitRatio: 2}}, "qfq", []float64{5, 5, 6}},
+		{"split hfq", []float64{10, 5, 6}, []CorporateAction{{ExDate: "2024-01-02", SplitRatio: 2}}, "hfq", []float64{10, 10, 12}},
+		// Both on one ex-date, then a later dividend: (10 - 1) / 1.5 = 6, then 6 - 0.6 = 5.4
+		{"dividend and split qfq", []float64{10, 6, 5.4},
+			[]CorporateAction{{ExDate: "2024-01-02", CashPerShare: 1, SplitRatio: 1.5}, {ExDate: "2024-01-03", CashPerShare: 0.6}},
+			"qfq", []float64{5.4, 5.4, 5.4}},
+		{"dividend and split hfq", []float64{10, 6, 5.4},
+			[]CorporateAction{{ExDate: "2024-01-02", CashPerShare: 1, SplitRatio: 1.5}, {ExDate: "2024-01-03", CashPerShare: 0.6}},
+			"hfq", []float64{10, 10, 10}},
+		// An ex-date on the first bar has no close before it in the series
+		{"first bar ex-date", []float64{9, 9}, []CorporateAction{{ExDate: "2024-01-01", CashPerShare: 1}}, "qfq", []float64{9, 9}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := adjustKlines(bars(tc.closes...), tc.actions, tc.mode)
+			for i, k := range out {
+				if math.Abs(k.Close-tc.want[i]) > 1e-9 || k.Open != k.Close || k.High != k.Close || k.Low != k.Close {
+					t.Errorf("bar %d: got %+v, want every price %.3f", i, k, tc.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FeeBreakdown itemizes the costs of one fill, or of several fills when summed.
+type FeeBreakdown struct {
+	Commission  float64 `json:"commission"`  // Broker commission (exchange fee for crypto)
+	StampDuty   float64 `json:"stampDuty"`   // A-share: sells (both sides before 2008-09-19); HK: both sides
+	TransferFee float64 `json:"transferFee"` // A-share 过户费
+	Levy        float64 `json:"levy"`        // HK SFC + AFRC levies and HKEX trading fee
+	Settlement  float64 `json:"settlement"`  // HK CCASS settlement fee
+}
+
+func (f FeeBreakdown) Total() float64 {
+	return f.Commission + f.StampDuty + f.TransferFee + f.Levy + f.Settlement
+}
+
+func (f *FeeBreakdown) Add(o FeeBreakdown) {
+	f.Commission += o.Commission
+	f.StampDuty += o.StampDuty
+	f.TransferFee += o.TransferFee
+	f.Levy += o.Levy
+	f.Settlement += o.Settlement
+}
+
+func (f FeeBreakdown) Rounded() FeeBreakdown {
+	return FeeBreakdown{
+		Commission:  RoundTo3(f.Commission),
+		StampDuty:   RoundTo3(f.StampDuty),
+		TransferFee: RoundTo3(f.TransferFee),
+		Levy:        RoundTo3(f.Levy),
+		Settlement:  RoundTo3(f.Settlement),
+	}
+}
+
+// FeeModel prices the costs of a fill of amount shares at price (after slippage) on
+// date, the trading day (YYYY-MM-DD) the statutory rates are looked up for.
+type FeeModel interface {
+	Fees(side, date string, price, amount float64) FeeBreakdown
+}
+
+// datedRate is a statutory rate in force from From (YYYY-MM-DD) until the next entry.
+type datedRate struct {
+	From string
+	Rate float64
+}
+
+// rateOn returns the rate of schedule in force on date. The schedule is sorted by
+// From and starts early enough for the data the app holds; a date before its first
+// entry gets the first rate.
+func rateOn(schedule []datedRate, date string) float64 {
+	rate := schedule[0].Rate
+	for _, r := range schedule {
+		if date < r.From {
+			break
+		}
+		rate = r.Rate
+	}
+	return rate
+}
+
+// simpleFees is the original single-line model: max(value * rate, minimum).
+type simpleFees struct {
+	rate, min float64
+}
+
+func (m simpleFees) Fees(side, date string, price, amount float64) FeeBreakdown {
+	return FeeBreakdown{Commission: math.Max(price*amount*m.rate, m.min)}
+}
+
+// aShareFees adds the statutory charges on top of the broker commission.
+// Funds (ETF / LOF) are exempt from both stamp duty and the transfer fee.
+type aShareFees struct {
+	broker simpleFees
+	fund   bool
+}
+
+// aShareStampDuty is charged on sells, and until 2008-09-19 on buys as well
+// (aShareBuyStampDuty).
+var aShareStampDuty = []datedRate{
+	{"1991-10-10", 0.003},
+	{"1997-05-12", 0.005},
+	{"1998-06-12", 0.004},
+	{"2001-11-16", 0.002},
+	{"2005-01-24", 0.001},
+	{"2007-05-30", 0.003},
+	{"2008-04-24", 0.001},
+	{"2023-08-28", 0.0005},
+}
+
+var aShareBuyStampDuty = []datedRate{
+	{"1991-10-10", 0.003},
+	{"1997-05-12", 0.005},
+	{"1998-06-12", 0.004},
+	{"2001-11-16", 0.002},
+	{"2005-01-24", 0.001},
+	{"2007-05-30", 0.003},
+	{"2008-04-24", 0.001},
+	{"2008-09-19", 0},
+}
+
+// aShareTransferFee is charged on both sides, by value on both exchanges since
+// 2015-08-01. Before that only Shanghai charged it, per share, which is not modeled.
+var aShareTransferFee = []datedRate{
+	{"1990-12-19", 0},
+	{"2015-08-01", 0.00002},
+	{"2022-04-29", 0.00001},
+}
+
+func (m aShareFees) Fees(side, date string, price, amount float64) FeeBreakdown {
+	f := m.broker.Fees(side, date, price, amount)
+	if m.fund {
+		return f
+	}
+	value := price * amount
+	if side == "SELL" {
+		f.StampDuty = value * rateOn(aShareStampDuty, date)
+	} else {
+		f.StampDuty = value * rateOn(aShareBuyStampDuty, date)
+	}
+	f.TransferFee = value * rateOn(aShareTransferFee, date)
+	return f
+}
+
+// hkFees follows the HKEX schedule: stamp duty both ways rounded up to a whole dollar,
+// SFC / AFRC levies, the trading fee and the CCASS settlement fee. ETFs are exempt
+// from stamp duty.
+type hkFees struct {
+	broker simpleFees
+	fund   bool
+}
+
+// hkStampDuty is charged on both sides.
+var hkStampDuty = []datedRate{
+	{"2001-09-01", 0.001},
+	{"2021-08-01", 0.0013},
+	{"2023-11-17", 0.001},
+}
+
+// hkTradingFee is the HKEX trading fee and hkAFRCLevy the AFRC levy introduced in 2022.
+var (
+	hkTradingFee = []datedRate{
+		{"2000-01-01", 0.00005},
+		{"2023-01-01", 0.0000565},
+	}
+	hkAFRCLevy = []datedRate{
+		{"2000-01-01", 0},
+		{"2022-01-01", 0.0000015},
+	}
+)
+
+const hkSFCLevyRate = 0.000027
+
+// ccassTariff is a CCASS stock settlement fee in force from From: Rate of the value,
+// within [Min, Max] when they are set.
+type ccassTariff struct {
+	From           string
+	Rate, Min, Max float64
+}
+
+// hkCCASS is the settlement fee schedule: 0.002% between HK$2 and HK$100 a side,
+// until the 2023 fee revision replaced it with 0.0042% without a minimum or maximum.
+var hkCCASS = []ccassTariff{
+	{"2000-01-01", 0.00002, 2, 100},
+	{"2023-01-01", 0.000042, 0, 0},
+}
+
+func ccassFee(date string, value float64) float64 {
+	t := hkCCASS[0]
+	for _, c := range hkCCASS {
+		if date < c.From {
+			break
+		}
+		t = c
+	}
+	fee := value * t.Rate
+	if t.Min > 0 {
+		fee = math.Max(fee, t.Min)
+	}
+	if t.Max > 0 {
+		fee = math.Min(fee, t.Max)
+	}
+	return fee
+}
+
+func (m hkFees) Fees(side, date string, price, amount float64) FeeBreakdown {
+	f := m.broker.Fees(side, date, price, amount)
+	value := price * amount
+	if !m.fund {
+		f.StampDuty = math.Ceil(value * rateOn(hkStampDuty, date))
+	}
+	f.Levy = value * (hkSFCLevyRate + rateOn(hkAFRCLevy, date) + rateOn(hkTradingFee, date))
+	f.Settlement = ccassFee(date, value)
+	return f
+}
+
+// binanceFees is the spot schedule of the regular tier (VIP 0), where maker and taker
+// orders both pay 0.1%; paying fees in BNB takes 25% off.
+type binanceFees struct {
+	bnb bool
+}
+
+const (
+	binanceRate        = 0.001
+	binanceBNBDiscount = 0.25
+)
+
+func (m binanceFees) Fees(side, date string, price, amount float64) FeeBreakdown {
+	rate := binanceRate
+	if m.bnb {
+		rate *= 1 - binanceBNBDiscount
+	}
+	return FeeBreakdown{Commission: price * amount * rate}
+}
+
+// feeModels lists the presets accepted by SimConfig.FeeModel.
+var feeModels = map[string]func(config SimConfig) FeeModel{
+	"simple": func(c SimConfig) FeeModel { return simpleFees{c.CommissionRate, c.MinCommission} },
+	"ashare_stock": func(c SimConfig) FeeModel {
+		return aShareFees{broker: simpleFees{c.CommissionRate, c.MinCommission}}
+	},
+	"ashare_fund": func(c SimConfig) FeeModel {
+		return aShareFees{broker: simpleFees{c.CommissionRate, c.MinCommission}, fund: true}
+	},
+	"hk": func(c SimConfig) FeeModel { return hkFees{broker: simpleFees{c.CommissionRate, c.MinCommission}} },
+	"hk_fund": func(c SimConfig) FeeModel {
+		return hkFees{broker: simpleFees{c.CommissionRate, c.MinCommission}, fund: true}
+	},
+	"binance": func(c SimConfig) FeeModel { return binanceFees{bnb: c.BNBDiscount} },
+}
+
+func validateFeeModel(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := feeModels[name]; !ok {
+		return fmt.Errorf("unknown fee model: %s", name)
+	}
+	return nil
+}
+
+// feeModelName resolves the preset for a config: the explicit choice, or the one
+// matching the symbol's market.
+func feeModelName(config SimConfig) string {
+	if config.FeeModel != "" {
+		return config.FeeModel
+	}
+	switch detectMarket(config.Symbol) {
+	case MarketAShare:
+		if isAShareFund(config.Symbol) {
+			return "ashare_fund"
+		}
+		return "ashare_stock"
+	case MarketHK:
+		if isHKFund(config.Symbol) {
+			return "hk_fund"
+		}
+		return "hk"
+	case MarketCrypto:
+		return "binance"
+	}
+	return "simple"
+}
+
+func newFeeModel(config SimConfig) FeeModel {
+	return feeModels[feeModelName(config)](config)
+}
+
+// hkFundRanges are the HKEX stock code ranges allocated to ETFs, including leveraged
+// and inverse products and the USD and RMB counters.
+var hkFundRanges = [][2]int{
+	{2800, 2849}, {3000, 3199}, {7200, 7399}, {7500, 7599},
+	{9000, 9199}, {9800, 9849}, {82800, 82849}, {83000, 83199},
+}
+
+// isHKFund reports whether an HK code is an ETF, e.g. 02800 盈富基金.
+func isHKFund(symbol string) bool {
+	code, err := strconv.Atoi(strings.TrimSpace(symbol))
+	if err != nil {
+		return false
+	}
+	for _, r := range hkFundRanges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
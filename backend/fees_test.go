@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFeeModels(t *testing.T) {
+	cases := []struct {
+		name          string
+		config        SimConfig
+		side, date    string
+		price, amount float64
+		want          FeeBreakdown
+	}{
+		// A-share stock, 0.025% commission with a ¥5 minimum, ¥10,000 a fill
+		{"ashare sell", SimConfig{Symbol: "600519", CommissionRate: 0.00025, MinCommission: 5}, "SELL", "2024-01-02", 10, 1000,
+			FeeBreakdown{Commission: 5, StampDuty: 5, TransferFee: 0.1}},
+		{"ashare buy", SimConfig{Symbol: "600519", CommissionRate: 0.00025, MinCommission: 5}, "BUY", "2024-01-02", 10, 1000,
+			FeeBreakdown{Commission: 5, TransferFee: 0.1}},
+		{"ashare sell before the 2023 cut", SimConfig{Symbol: "600519", CommissionRate: 0.00025, MinCommission: 5}, "SELL", "2023-08-25", 10, 1000,
+			FeeBreakdown{Commission: 5, StampDuty: 10, TransferFee: 0.1}},
+		{"ashare sell before the 2022 transfer fee cut", SimConfig{Symbol: "000001", CommissionRate: 0.00025, MinCommission: 5}, "SELL", "2020-06-01", 10, 1000,
+			FeeBreakdown{Commission: 5, StampDuty: 10, TransferFee: 0.2}},
+		{"ashare buy with stamp duty on both sides", SimConfig{Symbol: "600519", CommissionRate: 0.00025, MinCommission: 5}, "BUY", "2008-06-02", 10, 1000,
+			FeeBreakdown{Commission: 5, StampDuty: 10}},
+		{"ashare buy after stamp duty went sell-only", SimConfig{Symbol: "600519", CommissionRate: 0.00025, MinCommission: 5}, "BUY", "2008-10-06", 10, 1000,
+			FeeBreakdown{Commission: 5}},
+		{"ashare etf", SimConfig{Symbol: "512890", CommissionRate: 0.00025, MinCommission: 5}, "SELL", "2024-01-02", 10, 1000,
+			FeeBreakdown{Commission: 5}},
+
+		// HK, 0.03% commission, HK$300,000 a fill
+		{"hk buy", SimConfig{Symbol: "00700", CommissionRate: 0.0003}, "BUY", "2024-01-02", 300, 1000,
+			FeeBreakdown{Commission: 90, StampDuty: 300, Levy: 25.5, Settlement: 12.6}},
+		{"hk during the 0.13% stamp duty", SimConfig{Symbol: "00700", CommissionRate: 0.0003}, "SELL", "2022-06-01", 300, 1000,
+			FeeBreakdown{Commission: 90, StampDuty: 390, Levy: 23.55, Settlement: 6}},
+		{"hk before the AFRC levy", SimConfig{Symbol: "00700", CommissionRate: 0.0003}, "BUY", "2020-06-01", 300, 1000,
+			FeeBreakdown{Commission: 90, StampDuty: 300, Levy: 23.1, Settlement: 6}},
+		{"hk stamp duty rounds up", SimConfig{Symbol: "00700"}, "BUY", "2024-01-02", 300.5, 10,
+			FeeBreakdown{StampDuty: 4, Levy: 0.255425, Settlement: 0.12621}},
+		{"hk ccass minimum", SimConfig{Symbol: "00700"}, "BUY", "2020-06-01", 100, 100,
+			FeeBreakdown{StampDuty: 10, Levy: 0.77, Settlement: 2}},
+		{"hk ccass maximum", SimConfig{Symbol: "00700"}, "BUY", "2020-06-01", 500, 20000,
+			FeeBreakdown{StampDuty: 10000, Levy: 770, Settlement: 100}},
+		{"hk ccass uncapped", SimConfig{Symbol: "00700"}, "BUY", "2024-01-02", 500, 20000,
+			FeeBreakdown{StampDuty: 10000, Levy: 850, Settlement: 420}},
+		{"hk etf", SimConfig{Symbol: "02800", CommissionRate: 0.0003}, "BUY", "2024-01-02", 300, 1000,
+			FeeBreakdown{Commission: 90, Levy: 25.5, Settlement: 12.6}},
+
+		// Binance spot, 20,000 USDT a fill
+		{"binance", SimConfig{Symbol: "BTCUSDT"}, "BUY", "2024-01-02", 40000, 0.5,
+			FeeBreakdown{Commission: 20}},
+		{"binance bnb", SimConfig{Symbol: "BTCUSDT", BNBDiscount: true}, "SELL", "2024-01-02", 40000, 0.5,
+			FeeBreakdown{Commission: 15}},
+	}
+	for _, tc := range cases {
+		got := newFeeModel(tc.config).Fees(tc.side, tc.date, tc.price, tc.amount)
+		for _, f := range []struct {
+			field     string
+			got, want float64
+		}{
+			{"commission", got.Commission, tc.want.Commission},
+			{"stampDuty", got.StampDuty, tc.want.StampDuty},
+			{"transferFee", got.TransferFee, tc.want.TransferFee},
+			{"levy", got.Levy, tc.want.Levy},
+			{"settlement", got.Settlement, tc.want.Settlement},
+		} {
+			if math.Abs(f.got-f.want) > 1e-6 {
+				t.Errorf("%s: %s got %.6f, want %.6f", tc.name, f.field, f.got, f.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ladder maps grid indices to price levels. Index 0 is the level at (or nearest to) the
+// base price and positive indices lie above it. Levels rise strictly with the index;
+// past the end of a finite ladder Level returns -Inf below and +Inf above, which no
+// price ever reaches.
+type Ladder interface {
+	Level(idx int) float64
+	// IndexOf returns the level between the base and price that is closest to price.
+	IndexOf(price float64) int
+}
+
+// ladderTypes lists the supported SimConfig.LadderType values.
+var ladderTypes = map[string]bool{
+	"":           true, // Same as "arithmetic"
+	"arithmetic": true, // Even spacing from GridStep / GridStepType (the original grid)
+	"geometric":  true, // Constant ratio between levels: BasePrice * (1 + GridStep%)^idx
+	"dense":      true, // Gaps widen away from base: offset = step * |idx|^LadderExponent
+	"log":        true, // LadderCount levels evenly spaced in log price over [LadderMin, LadderMax]
+	"explicit":   true, // LadderLevels exactly as given
+}
+
+// maxLadderLevels caps LadderCount and LadderLevels, which size the level list and
+// the preview.
+const maxLadderLevels = 1000
+
+func validateLadder(config SimConfig) error {
+	if !ladderTypes[config.LadderType] {
+		return fmt.Errorf("unknown ladder type: %s", config.LadderType)
+	}
+	if config.LadderCount > maxLadderLevels || len(config.LadderLevels) > maxLadderLevels {
+		return fmt.Errorf("ladderCount and ladderLevels must not exceed %d levels", maxLadderLevels)
+	}
+	switch config.LadderType {
+	case "geometric":
+		if config.GridStepType == "absolute" {
+			return fmt.Errorf("geometric ladder needs a percent gridStep")
+		}
+	case "dense":
+		if config.LadderExponent < 0 {
+			return fmt.Errorf("ladderExponent must not be negative")
+		}
+	case "log":
+		if config.LadderMin <= 0 || config.LadderMax <= config.LadderMin || config.LadderCount < 2 {
+			return fmt.Errorf("log ladder needs 0 < ladderMin < ladderMax and ladderCount >= 2")
+		}
+		return nil
+	case "explicit":
+		if len(config.LadderLevels) < 2 {
+			return fmt.Errorf("explicit ladder needs at least 2 ladderLevels")
+		}
+		for _, p := range config.LadderLevels {
+			if p <= 0 {
+				return fmt.Errorf("ladderLevels must be positive")
+			}
+		}
+		return nil
+	}
+	if config.GridStep <= 0 {
+		return fmt.Errorf("gridStep must be positive")
+	}
+	// Every level is an offset from the base, and a zero base never reaches the price
+	if config.BasePrice <= 0 {
+		return fmt.Errorf("basePrice must be positive")
+	}
+	return nil
+}
+
+// newLadder builds the configured ladder around base. Trailing grids call it again
+// whenever the base moves.
+func newLadder(config SimConfig, base float64) Ladder {
+	var l Ladder
+	switch config.LadderType {
+	case "geometric":
+		l = geometricLadder{base: base, ratio: 1 + config.GridStep/100}
+	case "dense":
+		exponent := config.LadderExponent
+		if exponent == 0 {
+			exponent = 1.5
+		}
+		step := config.GridStep
+		if config.GridStepType != "absolute" {
+			step = base * config.GridStep / 100
+		}
+		l = denseLadder{base: base, step: step, exponent: exponent}
+	case "log":
+		l = newListLadder(logLevels(config.LadderMin, config.LadderMax, config.LadderCount), base)
+	case "explicit":
+		l = newListLadder(config.LadderLevels, base)
+	default:
+		l = arithmeticLadder{base: base, step: config.GridStep / 100.0}
+		if config.GridStepType == "absolute" {
+			l = arithmeticLadder{base: base, step: config.GridStep, absolute: true}
+		}
+	}
+	if config.SnapToTick && symbolTick(config.Symbol, base) > 0 {
+		l = &snappedLadder{inner: l, symbol: config.Symbol, cache: make(map[int]float64)}
+	}
+	return l
+}
+
+// arithmeticLadder is the original grid: BasePrice*(1+idx*step) or BasePrice+idx*step.
+type arithmeticLadder struct {
+	base     float64
+	step     float64 // Fraction of base, or a price distance when absolute
+	absolute bool
+}
+
+func (l arithmeticLadder) Level(idx int) float64 {
+	if l.absolute {
+		return RoundTo3(l.base + float64(idx)*l.step)
+	}
+	return RoundTo3(l.base * (1 + float64(idx)*l.step))
+}
+
+func (l arithmeticLadder) IndexOf(price float64) int {
+	if l.absolute {
+		return int((price - l.base) / l.step)
+	}
+	return int((price/l.base - 1) / l.step)
+}
+
+type geometricLadder struct {
+	base  float64
+	ratio float64
+}
+
+func (l geometricLadder) Level(idx int) float64 {
+	return RoundTo3(l.base * math.Pow(l.ratio, float64(idx)))
+}
+
+func (l geometricLadder) IndexOf(price float64) int {
+	return int(math.Log(price/l.base) / math.Log(l.ratio))
+}
+
+type denseLadder struct {
+	base     float64
+	step     float64 // Offset of the first level on either side
+	exponent float64
+}
+
+func (l denseLadder) Level(idx int) float64 {
+	offset := l.step * math.Pow(math.Abs(float64(idx)), l.exponent)
+	if idx < 0 {
+		offset = -offset
+	}
+	return RoundTo3(l.base + offset)
+}
+
+func (l denseLadder) IndexOf(price float64) int {
+	d := price - l.base
+	n := int(math.Pow(math.Abs(d)/l.step, 1/l.exponent))
+	if d < 0 {
+		return -n
+	}
+	return n
+}
+
+// listLadder is a finite, sorted list of levels with index 0 at the level nearest base.
+type listLadder struct {
+	levels  []float64
+	baseIdx int
+}
+
+func newListLadder(levels []float64, base float64) listLadder {
+	sorted := append([]float64(nil), levels...)
+	sort.Float64s(sorted)
+	l := listLadder{}
+	for _, p := range sorted {
+		if n := len(l.levels); n == 0 || p > l.levels[n-1] {
+			l.levels = append(l.levels, p)
+		}
+	}
+	for i, p := range l.levels {
+		if math.Abs(p-base) < math.Abs(l.levels[l.baseIdx]-base) {
+			l.baseIdx = i
+		}
+	}
+	return l
+}
+
+func (l listLadder) Level(idx int) float64 {
+	i := l.baseIdx + idx
+	if i < 0 {
+		return math.Inf(-1)
+	}
+	if i >= len(l.levels) {
+		return math.Inf(1)
+	}
+	return l.levels[i]
+}
+
+func (l listLadder) IndexOf(price float64) int {
+	if price >= l.levels[l.baseIdx] {
+		// Highest level at or below price
+		return sort.Search(len(l.levels), func(i int) bool { return l.levels[i] > price }) - 1 - l.baseIdx
+	}
+	// Lowest level at or above price
+	return sort.Search(len(l.levels), func(i int) bool { return l.levels[i] >= price }) - l.baseIdx
+}
+
+// logLevels returns count prices evenly spaced in log space from min to max.
+func logLevels(min, max float64, count int) []float64 {
+	levels := make([]float64, count)
+	ratio := math.Log(max / min)
+	for i := range levels {
+		levels[i] = RoundTo3(min * math.Exp(ratio*float64(i)/float64(count-1)))
+	}
+	return levels
+}
+
+// snappedLadder rounds every level of inner to the symbol's tick size. Levels closer
+// together than a tick would collapse onto one price, so each level is kept at least
+// one tick beyond its neighbour on the base side.
+type snappedLadder struct {
+	inner  Ladder
+	symbol string
+	cache  map[int]float64
+}
+
+func (l *snappedLadder) Level(idx int) float64 {
+	if p, ok := l.cache[idx]; ok {
+		return p
+	}
+	p := l.inner.Level(idx)
+	if math.IsInf(p, 0) {
+		return p
+	}
+	tick := symbolTick(l.symbol, p)
+	p = RoundTo3(math.Round(p/tick) * tick)
+	switch {
+	case idx > 0:
+		if prev := l.Level(idx - 1); p <= prev {
+			p = RoundTo3(prev + symbolTick(l.symbol, prev))
+		}
+	case idx < 0:
+		if next := l.Level(idx + 1); p >= next {
+			p = RoundTo3(next - symbolTick(l.symbol, next-0.00001))
+		}
+	}
+	l.cache[idx] = p
+	return p
+}
+
+func (l *snappedLadder) IndexOf(price float64) int {
+	// Start from the unsnapped index and walk to the right snapped level
+	i := l.inner.IndexOf(price)
+	if price >= l.Level(0) {
+		for l.Level(i+1) <= price {
+			i++
+		}
+		for i > 0 && l.Level(i) > price {
+			i--
+		}
+		return i
+	}
+	for l.Level(i-1) >= price {
+		i--
+	}
+	for i < 0 && l.Level(i) < price {
+		i++
+	}
+	return i
+}
+
+// hkTickTable is the HKEX spread table: the tick applies to prices up to the bound.
+var hkTickTable = []struct{ upTo, tick float64 }{
+	{0.25, 0.001},
+	{0.50, 0.005},
+	{10, 0.01},
+	{20, 0.02},
+	{100, 0.05},
+	{200, 0.1},
+	{500, 0.2},
+	{1000, 0.5},
+	{2000, 1},
+	{5000, 2},
+	{math.Inf(1), 5},
+}
+
+func hkTick(price float64) float64 {
+	for _, row := range hkTickTable {
+		if price <= row.upTo {
+			return row.tick
+		}
+	}
+	return 5
+}
+
+// symbolTick is the minimum price increment of symbol at price, 0 when unknown.
+func symbolTick(symbol string, price float64) float64 {
+	switch detectMarket(symbol) {
+	case MarketAShare:
+		return aShareTick(symbol)
+	case MarketHK:
+		return hkTick(price)
+	case MarketGold:
+		return 0.01
+	}
+	return 0
+}
+
+// ladderPreview lists the levels a config trades on: the whole list for finite
+// ladders, otherwise count levels on each side of the base.
+func ladderPreview(config SimConfig, count int) []float64 {
+	l := newLadder(config, config.BasePrice)
+	if config.LadderType == "log" || config.LadderType == "explicit" {
+		// Finite ladders: wide enough to list every level whichever one is the base
+		count = len(config.LadderLevels) + config.LadderCount
+	}
+	var levels []float64
+	for idx := -count; idx <= count; idx++ {
+		if p := l.Level(idx); !math.IsInf(p, 0) && p > 0 {
+			levels = append(levels, p)
+		}
+	}
+	return levels
+}
+
+// previewLadder handles POST /api/simulate/ladder: generate (or normalize an uploaded)
+// level list from the ladder fields of a SimConfig.
+func previewLadder(c *gin.Context) {
+	var config SimConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateLadder(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	count := config.LadderCount
+	if count <= 0 {
+		count = 10
+	}
+	c.JSON(http.StatusOK, gin.H{"data": ladderPreview(config, count)})
+}
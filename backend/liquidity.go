@@ -0,0 +1,146 @@
+package main
+
+import "fmt"
+
+// restingOrder is the unfilled remainder of an order cut short by the bar's volume.
+// It keeps working at the order's price on later bars of the session it was placed in.
+type restingOrder struct {
+	Order
+	session string
+}
+
+// restingAware is implemented by strategies that keep state for their orders. The
+// engine reports every fill of a resting remainder and the shares still resting after
+// it; left is 0 once the remainder has filled, expired or been cancelled.
+type restingAware interface {
+	OnResting(o Order, filled, left float64)
+}
+
+func validateLiquidity(config SimConfig) error {
+	if config.MaxVolumePct < 0 || config.MaxVolumePct > 100 {
+		return fmt.Errorf("maxVolumePct must be between 0 and 100")
+	}
+	return nil
+}
+
+// liquidityCap returns how much of an order for amount shares the rest of the bar's
+// volume allows, rounded down to the lot rules.
+func (e *simEngine) liquidityCap(amount float64) float64 {
+	if e.config.MaxVolumePct <= 0 || e.market.VolumeUnit <= 0 || amount <= e.volumeLeft+1e-9 {
+		return amount
+	}
+	if e.volumeLeft <= 0 {
+		return 0
+	}
+	if e.config.LotRules || e.config.SizingUnit == "value" {
+		return e.lotRule.round(e.volumeLeft)
+	}
+	return e.volumeLeft
+}
+
+// fillPartial executes the allowed part of o. A strategy order's remainder rests at its
+// price; an exit order is resubmitted by the exit on the next bar instead.
+func (e *simEngine) fillPartial(o Order, allowed float64) (OrderStatus, float64) {
+	requested := o.Amount
+	if allowed > 0 {
+		o.Amount = allowed
+		if status := e.execute(o); status != OrderFilled {
+			return status, 0
+		}
+		e.placeOrders(o)
+		e.volumeLeft -= allowed
+		e.result.Trades[len(e.result.Trades)-1].Requested = requested
+	}
+	e.result.PartialFills++
+	e.orderedShares += requested
+	e.filledShares += allowed
+	if o.Reason == "" {
+		o.Amount = requested - allowed
+		e.resting = append(e.resting, restingOrder{Order: o, session: e.stat.Date})
+	}
+	return OrderPartial, allowed
+}
+
+// workResting fills the resting remainders the current bar trades through, oldest
+// first, before the strategy sees the bar.
+func (e *simEngine) workResting() {
+	kept := e.resting[:0]
+	for _, r := range e.resting {
+		if !e.restingReached(r.Order) {
+			kept = append(kept, r)
+			continue
+		}
+		amount := e.liquidityCap(r.Amount)
+		if r.Side == "SELL" && e.lots.sellable(e.stat.Date) < amount-0.0001 {
+			// Wait for the shares to settle rather than count a blocked sell on every bar
+			amount = 0
+		}
+		if amount <= 0 {
+			kept = append(kept, r)
+			continue
+		}
+
+		piece := r.Order
+		piece.Amount = amount
+		if e.execute(piece) != OrderFilled {
+			// The cash or the shares are gone: the rest is cancelled
+			e.result.ExpiredShares += r.Amount
+			e.notifyResting(r.Order, 0, 0)
+			continue
+		}
+		e.result.Trades[len(e.result.Trades)-1].Resting = true
+		e.result.RestingFills++
+		e.volumeLeft -= amount
+		e.filledShares += amount
+
+		r.Amount -= amount
+		if r.Amount <= 1e-9 {
+			r.Amount = 0
+		}
+		e.notifyResting(r.Order, amount, r.Amount)
+		if r.Amount > 0 {
+			kept = append(kept, r)
+		}
+	}
+	e.resting = kept
+}
+
+// restingReached reports whether the current bar trades at or through the order's price.
+// Locked limit bars are skipped without counting as blocked orders.
+func (e *simEngine) restingReached(o Order) bool {
+	if e.limitLocked(o.Side) {
+		return false
+	}
+	if o.Side == "BUY" {
+		return RoundTo3(e.bar.Low) <= o.Price+0.00001
+	}
+	return RoundTo3(e.bar.High) >= o.Price-0.00001
+}
+
+// expireResting cancels the remainders of an earlier session; orders are day orders.
+func (e *simEngine) expireResting() {
+	kept := e.resting[:0]
+	for _, r := range e.resting {
+		if r.session == e.stat.Date {
+			kept = append(kept, r)
+			continue
+		}
+		e.result.ExpiredShares += r.Amount
+		e.notifyResting(r.Order, 0, 0)
+	}
+	e.resting = kept
+}
+
+// cancelResting drops every resting remainder when the run exits.
+func (e *simEngine) cancelResting() {
+	for _, r := range e.resting {
+		e.result.ExpiredShares += r.Amount
+	}
+	e.resting = nil
+}
+
+func (e *simEngine) notifyResting(o Order, filled, left float64) {
+	if s, ok := e.strategy.(restingAware); ok {
+		s.OnResting(o, filled, left)
+	}
+}
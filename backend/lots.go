@@ -0,0 +1,164 @@
+package main
+
+import "fmt"
+
+// Lot is one block of shares acquired by a single buy (or the initial base position).
+type Lot struct {
+	ID            int     `json:"id"`
+	OpenTime      string  `json:"openTime"`
+	Level         float64 `json:"level"`  // Grid level the buy was placed at (0 for the initial position)
+	Price         float64 `json:"price"`  // Cost per share including slippage, excluding fees
+	Amount        float64 `json:"amount"` // Shares still held
+	Initial       bool    `json:"initial"`
+	CostBasis     float64 `json:"costBasis"`     // Price * Amount, filled in on the final result
+	UnrealizedPnL float64 `json:"unrealizedPnl"` // Against the last close, filled in on the final result
+
+	fee     float64 // Buy costs per share, charged to the round trips that close the lot
+	session string  // Trading day the lot was opened in
+}
+
+// lotMatchRules lists the supported SimConfig.LotMatching values.
+var lotMatchRules = map[string]bool{
+	"":     true, // Same as "grid"
+	"grid": true, // The lot bought at the level the sell closes, then newest first
+	"fifo": true, // Oldest lot first
+	"lifo": true, // Newest lot first
+}
+
+func validateLotMatching(rule string) error {
+	if !lotMatchRules[rule] {
+		return fmt.Errorf("unknown lot matching rule: %s", rule)
+	}
+	return nil
+}
+
+// lotFill is the part of a lot consumed by one sell.
+type lotFill struct {
+	Lot    Lot
+	Amount float64
+}
+
+// lotBook keeps open lots in acquisition order and consumes them on sells.
+// Under T+1 a lot opened during the current session is not sellable yet.
+type lotBook struct {
+	rule   string
+	t1     bool
+	lots   []*Lot
+	nextID int
+}
+
+func (b *lotBook) open(l Lot) {
+	b.nextID++
+	l.ID = b.nextID
+	b.lots = append(b.lots, &l)
+}
+
+func (b *lotBook) total() float64 {
+	sum := 0.0
+	for _, l := range b.lots {
+		sum += l.Amount
+	}
+	return sum
+}
+
+func (b *lotBook) settled(l *Lot, session string) bool {
+	return !b.t1 || l.Initial || l.session < session
+}
+
+// sellable returns the shares that may be sold during session.
+func (b *lotBook) sellable(session string) float64 {
+	sum := 0.0
+	for _, l := range b.lots {
+		if b.settled(l, session) {
+			sum += l.Amount
+		}
+	}
+	return sum
+}
+
+// matchOrder returns lot indices in the order the rule consumes them.
+func (b *lotBook) matchOrder(matchLevel float64) []int {
+	n := len(b.lots)
+	order := make([]int, 0, n)
+	switch b.rule {
+	case "fifo":
+		for i := 0; i < n; i++ {
+			order = append(order, i)
+		}
+	case "lifo":
+		for i := n - 1; i >= 0; i-- {
+			order = append(order, i)
+		}
+	default:
+		for i := n - 1; i >= 0; i-- {
+			if !b.lots[i].Initial && b.lots[i].Level == matchLevel {
+				order = append(order, i)
+			}
+		}
+		for i := n - 1; i >= 0; i-- {
+			if b.lots[i].Initial || b.lots[i].Level != matchLevel {
+				order = append(order, i)
+			}
+		}
+	}
+	return order
+}
+
+// consume removes amount settled shares from the book and returns the pieces taken.
+func (b *lotBook) consume(amount, matchLevel float64, session string) []lotFill {
+	var fills []lotFill
+	remaining := amount
+	for _, i := range b.matchOrder(matchLevel) {
+		if remaining <= 0.0001 {
+			break
+		}
+		l := b.lots[i]
+		if !b.settled(l, session) {
+			continue
+		}
+		take := l.Amount
+		if take > remaining {
+			take = remaining
+		}
+		fills = append(fills, lotFill{Lot: *l, Amount: take})
+		l.Amount -= take
+		remaining -= take
+	}
+
+	kept := b.lots[:0]
+	for _, l := range b.lots {
+		if l.Amount > 0.0001 {
+			kept = append(kept, l)
+		}
+	}
+	b.lots = kept
+	return fills
+}
+
+// adjust applies a corporate action to every open lot: a split scales the shares and
+// their cost per share. Grid levels move to the ex-rights price only with shiftLevels,
+// in step with the strategy's own levels.
+func (b *lotBook) adjust(a CorporateAction, shiftLevels bool) {
+	ratio := a.ratio()
+	for _, l := range b.lots {
+		l.Amount *= ratio
+		l.Price /= ratio
+		l.fee /= ratio
+		if shiftLevels && !l.Initial {
+			l.Level = a.exRightsPrice(l.Level)
+		}
+	}
+}
+
+// snapshot values the open lots at price.
+func (b *lotBook) snapshot(price float64) []Lot {
+	out := make([]Lot, 0, len(b.lots))
+	for _, l := range b.lots {
+		lot := *l
+		lot.CostBasis = RoundTo3(lot.Price * lot.Amount)
+		lot.UnrealizedPnL = RoundTo3((price - lot.Price) * lot.Amount)
+		lot.Price = RoundTo3(lot.Price)
+		out = append(out, lot)
+	}
+	return out
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sizingUnits lists the supported SimConfig.SizingUnit values.
+var sizingUnits = map[string]bool{
+	"":       true, // Same as "shares"
+	"shares": true, // AmountPerGrid, BuyAmount, SellAmount and SizingTable are share counts
+	"value":  true, // They are currency amounts, converted to shares at the order price
+}
+
+func validateSizingUnit(unit string) error {
+	if !sizingUnits[unit] {
+		return fmt.Errorf("unknown sizing unit: %s", unit)
+	}
+	return nil
+}
+
+// LotRule is the quantity filter an exchange applies to orders.
+type LotRule struct {
+	Step        float64 `json:"step"`        // Quantity increment (0 = any quantity)
+	MinQty      float64 `json:"minQty"`      // Smallest order
+	MinNotional float64 `json:"minNotional"` // Smallest order value
+	OddLotSell  bool    `json:"oddLotSell"`  // A sell of the whole holding may be below Step (A-shares)
+}
+
+// RejectedOrder is an order dropped because it was below the lot rules after rounding.
+type RejectedOrder struct {
+	Time   string  `json:"time"`
+	Side   string  `json:"side"`
+	Price  float64 `json:"price"`
+	Amount float64 `json:"amount"` // Requested shares before rounding
+	Reason string  `json:"reason"` // "below_min_qty" | "below_min_notional"
+}
+
+// hkBoardLots are the board lots of commonly traded HK symbols; others default to 100
+// unless SimConfig.LotSize is set.
+var hkBoardLots = map[string]float64{
+	"00005": 400,  // 汇丰控股
+	"00388": 100,  // 香港交易所
+	"00700": 100,  // 腾讯控股
+	"00883": 1000, // 中国海洋石油
+	"00939": 1000, // 建设银行
+	"00941": 500,  // 中国移动
+	"01299": 200,  // 友邦保险
+	"01398": 1000, // 工商银行
+	"01810": 200,  // 小米集团
+	"02318": 500,  // 中国平安
+	"02800": 500,  // 盈富基金
+	"02828": 200,  // 恒生中国企业
+	"03033": 200,  // 南方恒生科技
+	"03690": 100,  // 美团
+	"09988": 100,  // 阿里巴巴
+}
+
+// binanceLotSizes are the LOT_SIZE step sizes of common USDT pairs.
+var binanceLotSizes = map[string]float64{
+	"BTCUSDT":  0.00001,
+	"ETHUSDT":  0.0001,
+	"BNBUSDT":  0.001,
+	"SOLUSDT":  0.001,
+	"XRPUSDT":  0.1,
+	"DOGEUSDT": 1,
+	"ADAUSDT":  0.1,
+}
+
+const (
+	binanceDefaultLotSize = 0.0001
+	binanceMinNotional    = 5.0 // USDT, the NOTIONAL filter of spot pairs
+)
+
+// lotRuleFor resolves the lot rules of the configured symbol. LotSize and MinNotional
+// override the step and the minimum order value.
+func lotRuleFor(config SimConfig) LotRule {
+	var rule LotRule
+	symbol := strings.ToUpper(strings.TrimSpace(config.Symbol))
+	switch detectMarket(config.Symbol) {
+	case MarketAShare:
+		rule = LotRule{Step: 100, MinQty: 100, OddLotSell: true}
+		if strings.HasPrefix(symbol, "688") || strings.HasPrefix(symbol, "689") {
+			// STAR Market: at least 200 shares, then any whole number
+			rule = LotRule{Step: 1, MinQty: 200, OddLotSell: true}
+		}
+	case MarketHK:
+		if len(symbol) < 5 {
+			symbol = strings.Repeat("0", 5-len(symbol)) + symbol
+		}
+		lot := hkBoardLots[symbol]
+		if lot == 0 {
+			lot = 100
+		}
+		rule = LotRule{Step: lot, MinQty: lot}
+	case MarketCrypto:
+		step := binanceLotSizes[symbol]
+		if step == 0 {
+			step = binanceDefaultLotSize
+		}
+		rule = LotRule{Step: step, MinQty: step, MinNotional: binanceMinNotional}
+	}
+	if config.LotSize > 0 {
+		rule.Step = config.LotSize
+		rule.MinQty = config.LotSize
+	}
+	if config.MinNotional > 0 {
+		rule.MinNotional = config.MinNotional
+	}
+	return rule
+}
+
+// round floors amount to the rule's step, with the float noise of small crypto steps removed.
+func (r LotRule) round(amount float64) float64 {
+	if r.Step <= 0 {
+		return amount
+	}
+	return math.Round(math.Floor(amount/r.Step+1e-9)*r.Step*1e8) / 1e8
+}
+
+// applyLotRules rounds an order to the lot rules. It returns false when nothing
+// tradable is left, recording the rejection.
+func (e *simEngine) applyLotRules(o Order) (Order, bool) {
+	if !e.config.LotRules && e.config.SizingUnit != "value" {
+		return o, true
+	}
+	rule := e.lotRule
+	requested := o.Amount
+	// An odd lot can only be sold as the whole remaining holding
+	wholeHolding := o.Side == "SELL" && rule.OddLotSell && math.Abs(o.Amount-e.pf.Holdings()) < 0.0001
+	if !wholeHolding {
+		o.Amount = rule.round(o.Amount)
+	}
+
+	reason := ""
+	switch {
+	case o.Amount <= 0 || (!wholeHolding && o.Amount < rule.MinQty-1e-9):
+		reason = "below_min_qty"
+	case rule.MinNotional > 0 && o.Amount*o.Price < rule.MinNotional:
+		reason = "below_min_notional"
+	}
+	if reason != "" {
+		// The grid retries a rejected level on every bar that reaches it; report it once
+		rejected := e.result.RejectedOrders
+		if n := len(rejected); n == 0 || rejected[n-1].Side != o.Side || rejected[n-1].Price != RoundTo3(o.Price) {
+			e.result.RejectedOrders = append(rejected, RejectedOrder{
+				Time:   e.bar.Timestamp,
+				Side:   o.Side,
+				Price:  RoundTo3(o.Price),
+				Amount: requested,
+				Reason: reason,
+			})
+		}
+		return o, false
+	}
+	if o.Amount < requested-1e-9 {
+		e.result.RoundedOrders++
+		e.result.RoundingRemainder += requested - o.Amount
+	}
+	return o, true
+}
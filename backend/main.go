@@ -66,7 +66,7 @@ func main() {
 	}
 
 	// Auto Migrate
-	DB.AutoMigrate(&Symbol{})
+	DB.AutoMigrate(&Symbol{}, &CorporateAction{}, &SimulationRun{})
 
 	// Create Indexes
 	DB.Exec("CREATE INDEX IF NOT EXISTS idx_1m_symbol_ts ON klines_1m(symbol, timestamp)")
@@ -88,7 +88,7 @@ func main() {
 
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -99,6 +99,8 @@ func main() {
 
 	// Register Simulation
 	RegisterSimulationRoutes(r)
+	RegisterCorporateActionRoutes(r)
+	RegisterRunRoutes(r)
 
 	// GET /api/symbols - Get list of supported symbols
 	r.GET("/api/symbols", func(c *gin.Context) {
@@ -125,9 +127,10 @@ func main() {
 		}
 
 		// Clean up kline data
-		tables := []string{"klines_1m", "klines_5m", "klines_daily"}
+		src := resolveMarket(symbol)
+		tables := []string{src.Table1m, src.Table5m, src.TableDaily}
 		for _, table := range tables {
-			if err := DB.Table(table).Where("symbol = ?", symbol).Delete(nil).Error; err != nil {
+			if err := DB.Table(table).Where("symbol = ?", src.Symbol).Delete(nil).Error; err != nil {
 				log.Printf("Warning: Failed to clean up table %s for symbol %s: %v", table, symbol, err)
 			}
 		}
@@ -325,10 +328,9 @@ func main() {
 
 		table1m := "klines_1m"
 		table5m := "klines_5m"
-		if symbol != "" && isHKSymbol(symbol) {
-			table1m = "hk_klines_1m"
-			table5m = "hk_klines_5m"
-			symbol = "HK." + symbol
+		if symbol != "" {
+			src := resolveMarket(symbol)
+			table1m, table5m, symbol = src.Table1m, src.Table5m, src.Symbol
 		}
 
 		var query string
@@ -369,13 +371,8 @@ func main() {
 			symbol = "512890"
 		}
 
-		table5m := "klines_5m"
-		table1m := "klines_1m"
-		if isHKSymbol(symbol) {
-			table5m = "hk_klines_5m"
-			table1m = "hk_klines_1m"
-			symbol = "HK." + symbol
-		}
+		src := resolveMarket(symbol)
+		table5m, table1m, symbol := src.Table5m, src.Table1m, src.Symbol
 
 		var klines5m, klines1m []Kline
 
@@ -427,12 +424,8 @@ func main() {
 		// 查询昨收价
 		var preClose *float64
 		if dateParam != "" {
-			dailyTable := "klines_daily"
-			if isHKSymbol(c.Query("symbol")) {
-				dailyTable = "hk_klines_daily"
-			}
 			var prevKline Kline
-			if err := DB.Table(dailyTable).Where("symbol = ? AND timestamp < ?", symbol, dateParam).Order("timestamp desc").First(&prevKline).Error; err == nil {
+			if err := DB.Table(src.TableDaily).Where("symbol = ? AND timestamp < ?", symbol, dateParam).Order("timestamp desc").First(&prevKline).Error; err == nil {
 				preClose = &prevKline.Close
 			}
 		}
@@ -446,21 +439,26 @@ func main() {
 
 	r.GET("/api/klines/daily", func(c *gin.Context) {
 		dateParam := c.Query("date")
+		adjust := c.Query("adjust") // "" (raw) | "qfq" (前复权) | "hfq" (后复权)
 		symbol := c.Query("symbol")
 		if symbol == "" {
 			symbol = "512890"
 		}
-
-		dailyTable := "klines_daily"
-		if isHKSymbol(symbol) {
-			dailyTable = "hk_klines_daily"
-			symbol = "HK." + symbol
+		if adjust != "" && adjust != "qfq" && adjust != "hfq" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "adjust must be qfq or hfq"})
+			return
 		}
+		actionSymbol := symbol
+
+		src := resolveMarket(symbol)
+		dailyTable := src.TableDaily
+		symbol = src.Symbol
 
 		var dailyKlines []Kline
 
 		query := DB.Table(dailyTable).Where("symbol = ?", symbol).Order("timestamp asc")
-		if dateParam != "" {
+		// 复权需要除权日前的收盘价，因此先取全量数据，复权后再按日期过滤
+		if dateParam != "" && adjust == "" {
 			query = query.Where("timestamp >= ? AND timestamp < ?", dateParam, dateParam+" 24:00")
 		}
 
@@ -468,6 +466,24 @@ func main() {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+
+		if adjust != "" {
+			actions, err := loadCorporateActions(actionSymbol)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			dailyKlines = adjustKlines(dailyKlines, actions, adjust)
+			if dateParam != "" {
+				filtered := []Kline{}
+				for _, k := range dailyKlines {
+					if strings.HasPrefix(k.Timestamp, dateParam) {
+						filtered = append(filtered, k)
+					}
+				}
+				dailyKlines = filtered
+			}
+		}
 		c.JSON(http.StatusOK, gin.H{"data": dailyKlines})
 	})
 
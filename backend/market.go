@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // MarketSource.Location must not depend on the host's zone database
+)
+
+// Market identifies the exchange rules a symbol trades under.
+type Market string
+
+const (
+	MarketAShare Market = "ashare"
+	MarketHK     Market = "hk"
+	MarketCrypto Market = "crypto"
+	MarketGold   Market = "gold"
+)
+
+// detectMarket classifies a symbol the same way the symbol endpoints do.
+func detectMarket(symbol string) Market {
+	symbolUpper := strings.ToUpper(strings.TrimSpace(symbol))
+	if symbolUpper == "XAU" {
+		return MarketGold
+	} else if strings.HasSuffix(symbolUpper, "USDT") {
+		return MarketCrypto
+	} else if isHKSymbol(symbol) {
+		return MarketHK
+	}
+	return MarketAShare
+}
+
+// MarketSource is where a symbol's bars are stored and how its trading days are cut.
+// The chart endpoints and the simulator both read bars through it.
+type MarketSource struct {
+	Market     Market
+	Symbol     string // As stored in the kline tables
+	Table1m    string
+	Table5m    string
+	TableDaily string
+	Timezone   string  // Zone of the stored timestamps
+	DayStart   string  // "HH:MM" a trading day starts at; earlier bars belong to the previous day ("" = midnight)
+	Suspends   bool    // A day without volume is a suspension
+	VolumeUnit float64 // Shares per unit of Kline.Volume (0 = the feed has no volume)
+}
+
+// resolveMarket returns the data source of symbol:
+//   - A-shares: klines_* in Beijing time
+//   - HK: hk_klines_* with the "HK." prefix, Hong Kong time
+//   - USDT pairs: klines_* under the upper-case pair in UTC, one day per UTC date like Binance's daily candles
+//   - XAU: klines_* in Beijing time; the session opens at 07:00, so night bars count toward the day before
+//
+// A-share volumes are stored in lots of 100 shares, HK and crypto volumes in shares
+// (base asset); the gold feed carries no volume.
+func resolveMarket(symbol string) MarketSource {
+	symbol = strings.TrimSpace(symbol)
+	src := MarketSource{
+		Market:     detectMarket(symbol),
+		Symbol:     symbol,
+		Table1m:    "klines_1m",
+		Table5m:    "klines_5m",
+		TableDaily: "klines_daily",
+		Timezone:   "Asia/Shanghai",
+	}
+	switch src.Market {
+	case MarketAShare:
+		src.Suspends = true
+		src.VolumeUnit = 100
+	case MarketHK:
+		src.Symbol = "HK." + symbol
+		src.Table1m = "hk_klines_1m"
+		src.Table5m = "hk_klines_5m"
+		src.TableDaily = "hk_klines_daily"
+		src.Timezone = "Asia/Hong_Kong"
+		src.Suspends = true
+		src.VolumeUnit = 1
+	case MarketCrypto:
+		src.Symbol = strings.ToUpper(symbol)
+		src.Timezone = "UTC"
+		src.VolumeUnit = 1
+	case MarketGold:
+		src.Symbol = "XAU"
+		src.DayStart = "07:00"
+	}
+	return src
+}
+
+// Location returns the zone of the source's timestamps.
+func (s MarketSource) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// SessionDate returns the trading day a bar timestamp belongs to.
+func (s MarketSource) SessionDate(ts string) string {
+	if s.DayStart == "" || len(ts) < 16 || ts[11:16] >= s.DayStart {
+		return ts[:10]
+	}
+	t, err := time.Parse("2006-01-02", ts[:10])
+	if err != nil {
+		return ts[:10]
+	}
+	return t.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// t0ETFPrefixes are A-share fund codes that settle T+0: Shanghai bond / money-market (511),
+// cross-border (513) and gold (518) ETFs.
+var t0ETFPrefixes = []string{"511", "513", "518"}
+
+// t0ETFs are Shenzhen T+0 ETFs, which share the 159 prefix with ordinary T+1 funds.
+var t0ETFs = map[string]bool{
+	"159920": true, // 恒生ETF
+	"159941": true, // 纳指ETF
+	"159934": true, // 黄金ETF
+	"159937": true, // 博时黄金
+	"159812": true, // 前海开源黄金
+	"159866": true, // 日经ETF
+	"159605": true, // 中概互联ETF
+	"159005": true, // 汇添富快钱
+}
+
+func isT0ETF(symbol string) bool {
+	if t0ETFs[symbol] {
+		return true
+	}
+	for _, p := range t0ETFPrefixes {
+		if strings.HasPrefix(symbol, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// settlementRules lists the supported SimConfig.Settlement values.
+var settlementRules = map[string]bool{
+	"":    true, // Derive from the symbol's market
+	"T+0": true,
+	"T+1": true,
+}
+
+func validateSettlement(rule string) error {
+	if !settlementRules[rule] {
+		return fmt.Errorf("unknown settlement rule: %s", rule)
+	}
+	return nil
+}
+
+// settlementRule resolves the effective rule: T+1 for A-shares except T+0 ETFs,
+// T+0 for HK, crypto and gold. An explicit override always wins.
+func settlementRule(symbol, override string) string {
+	if override != "" {
+		return override
+	}
+	if detectMarket(symbol) == MarketAShare && !isT0ETF(symbol) {
+		return "T+1"
+	}
+	return "T+0"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// barTime parses a bar timestamp ("2006-01-02 15:04[:05]" or a bare date).
+func barTime(ts string) time.Time {
+	return barTimeIn(ts, time.UTC)
+}
+
+// barTimeIn parses a bar timestamp stored in the zone loc.
+func barTimeIn(ts string, loc *time.Location) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, ts, loc); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// closeRoundTrip records the part of a grid lot closed by a sell at price. sellFee is
+// the share of the sell's costs that falls on this part.
+func (e *simEngine) closeRoundTrip(f lotFill, price, sellFee float64) {
+	net := (price-f.Lot.Price-f.Lot.fee)*f.Amount - sellFee
+	e.roundTrips++
+	if net > 0 {
+		e.roundTripWins++
+	}
+	e.holdingDays += barTime(e.bar.Timestamp).Sub(barTime(f.Lot.OpenTime)).Hours() / 24
+}
+
+// extendedMetrics fills in the risk, exposure and round-trip metrics. equity holds the
+// end-of-day equity for each entry of stats, measured against capital.
+func (e *simEngine) extendedMetrics(result *SimResult, stats []DailyStat, equity []float64, capital float64) {
+	if e.roundTrips > 0 {
+		result.RoundTrips = e.roundTrips
+		result.WinRate = RoundTo3(float64(e.roundTripWins) / float64(e.roundTrips) * 100)
+		result.AvgHoldingDays = RoundTo3(e.holdingDays / float64(e.roundTrips))
+	}
+
+	if result.MaxDrawdown > 0 {
+		result.CalmarRatio = RoundTo3(result.CAGR / result.MaxDrawdown)
+	}
+
+	var downside []float64
+	var returns []float64
+	for i := 1; i < len(equity); i++ {
+		r := (equity[i] - equity[i-1]) / equity[i-1]
+		returns = append(returns, r)
+		downside = append(downside, math.Min(r, 0))
+	}
+	if len(returns) > 0 {
+		// Downside deviation: only the losing days count as risk
+		sumSq := 0.0
+		for _, d := range downside {
+			sumSq += d * d
+		}
+		if dd := math.Sqrt(sumSq / float64(len(downside))); dd > 0 {
+			result.SortinoRatio = RoundTo3(Mean(returns) / dd * math.Sqrt(252))
+		}
+	}
+
+	peak := capital
+	run, ulcer := 0, 0.0
+	for _, eq := range equity {
+		if eq >= peak {
+			peak, run = eq, 0
+		} else {
+			run++
+			if run > result.MaxDrawdownDays {
+				result.MaxDrawdownDays = run
+			}
+		}
+		if peak > 0 {
+			dd := (peak - eq) / peak * 100
+			ulcer += dd * dd
+		}
+	}
+
+	held, utilization := 0, 0.0
+	for i, s := range stats {
+		if s.holdings > 0.0001 {
+			held++
+		}
+		if equity[i] > 0 {
+			utilization += s.holdings * s.ClosePrice / equity[i]
+		}
+	}
+	if n := len(stats); n > 0 {
+		result.UlcerIndex = RoundTo3(math.Sqrt(ulcer / float64(n)))
+		result.TimeInMarket = RoundTo3(float64(held) / float64(n) * 100)
+		result.CapitalUtilization = RoundTo3(utilization / float64(n) * 100)
+	}
+
+	if capital > 0 {
+		traded := 0.0
+		for _, t := range result.Trades {
+			traded += t.Price * t.Amount
+		}
+		result.Turnover = RoundTo3(traded / capital)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MonteCarloConfig reruns a SimConfig on price paths resampled from its history.
+type MonteCarloConfig struct {
+	SimConfig
+
+	Method    string `json:"method"`    // See resampleMethods
+	Runs      int    `json:"runs"`      // Number of resampled paths (default 200)
+	BlockDays int    `json:"blockDays"` // "block": trading days per block (default 5)
+	Seed      int64  `json:"seed"`      // Random seed, for repeatable results (0 = random)
+}
+
+// maxMonteCarloRuns caps the paths one request may simulate.
+const maxMonteCarloRuns = 2000
+
+// resampleMethods lists the supported MonteCarloConfig.Method values. Both rebuild a
+// path from whole trading days, each day's bars kept relative to the close before it.
+var resampleMethods = map[string]bool{
+	"":        true, // Same as "block"
+	"block":   true, // Block bootstrap: runs of BlockDays consecutive days, drawn with replacement
+	"shuffle": true, // Every historical day exactly once, in random order
+}
+
+// Distribution summarizes one metric across the resampled runs.
+type Distribution struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+	Min    float64 `json:"min"`
+	P5     float64 `json:"p5"`
+	P25    float64 `json:"p25"`
+	P50    float64 `json:"p50"`
+	P75    float64 `json:"p75"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+type MonteCarloRun struct {
+	Profit      float64 `json:"profit"` // TotalYieldAmount
+	MaxDrawdown float64 `json:"maxDrawdown"`
+	SharpeRatio float64 `json:"sharpeRatio"`
+	MissedBuys  int     `json:"missedBuys"`
+}
+
+type MonteCarloResult struct {
+	Method            string          `json:"method"`
+	Seed              int64           `json:"seed"`
+	Historical        MonteCarloRun   `json:"historical"` // The config on the actual history
+	Profit            Distribution    `json:"profit"`
+	MaxDrawdown       Distribution    `json:"maxDrawdown"`
+	SharpeRatio       Distribution    `json:"sharpeRatio"`
+	MissedBuys        Distribution    `json:"missedBuys"`
+	ProbabilityOfLoss float64         `json:"probabilityOfLoss"` // % of runs ending below zero
+	Runs              []MonteCarloRun `json:"runs"`
+}
+
+func runMonteCarlo(c *gin.Context) {
+	var config MonteCarloConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config.Symbol == "" {
+		config.Symbol = "512890"
+	}
+	if config.GridStep <= 0 {
+		config.GridStep = 1.0
+	}
+	if config.AmountPerGrid <= 0 {
+		config.AmountPerGrid = 100
+	}
+	if config.Runs <= 0 {
+		config.Runs = 200
+	}
+	if config.BlockDays <= 0 {
+		config.BlockDays = 5
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	if config.Runs > maxMonteCarloRuns {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("runs must not exceed %d", maxMonteCarloRuns)})
+		return
+	}
+	if !resampleMethods[config.Method] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown resample method: %s", config.Method)})
+		return
+	}
+	if err := validateSimConfig(config.SimConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := getSimulationData(config.SimConfig)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(data.Klines) == 0 {
+		c.JSON(http.StatusOK, MonteCarloResult{})
+		return
+	}
+
+	c.JSON(http.StatusOK, calcMonteCarlo(data, config))
+}
+
+func calcMonteCarlo(data SimData, config MonteCarloConfig) MonteCarloResult {
+	result := MonteCarloResult{Method: config.Method, Seed: config.Seed}
+	if result.Method == "" {
+		result.Method = "block"
+	}
+	result.Historical = monteCarloRun(calcSimulation(data, config.SimConfig))
+
+	days := data.splitDays()
+	result.Runs = make([]MonteCarloRun, config.Runs)
+	runParallel(config.Runs, func(i int) {
+		// One source per run keeps the result independent of the worker scheduling
+		r := rand.New(rand.NewSource(config.Seed + int64(i)))
+		path := resamplePath(days, data, result.Method, config.BlockDays, r)
+		result.Runs[i] = monteCarloRun(calcSimulation(path, config.SimConfig))
+	})
+
+	var profit, drawdown, sharpe, missed []float64
+	losses := 0
+	for _, run := range result.Runs {
+		profit = append(profit, run.Profit)
+		drawdown = append(drawdown, run.MaxDrawdown)
+		sharpe = append(sharpe, run.SharpeRatio)
+		missed = append(missed, float64(run.MissedBuys))
+		if run.Profit < 0 {
+			losses++
+		}
+	}
+	result.Profit = distribution(profit)
+	result.MaxDrawdown = distribution(drawdown)
+	result.SharpeRatio = distribution(sharpe)
+	result.MissedBuys = distribution(missed)
+	result.ProbabilityOfLoss = RoundTo3(float64(losses) / float64(len(result.Runs)) * 100)
+	return result
+}
+
+func monteCarloRun(res SimResult) MonteCarloRun {
+	return MonteCarloRun{
+		Profit:      res.TotalYieldAmount,
+		MaxDrawdown: res.MaxDrawdown,
+		SharpeRatio: res.SharpeRatio,
+		MissedBuys:  res.MissedBuys,
+	}
+}
+
+// splitDays groups d's bars by trading day.
+func (d SimData) splitDays() [][]Kline {
+	var days [][]Kline
+	for i, k := range d.Klines {
+		if i == 0 || d.Source.SessionDate(k.Timestamp) != d.Source.SessionDate(d.Klines[i-1].Timestamp) {
+			days = append(days, nil)
+		}
+		days[len(days)-1] = append(days[len(days)-1], k)
+	}
+	return days
+}
+
+// resamplePath builds a synthetic history of data as long as days, its trading days.
+// Each drawn day keeps its bars' moves relative to its own previous close and is moved
+// to the date of the slot it fills. The path has no limits, halts or corporate actions:
+// those belong to the real history.
+func resamplePath(days [][]Kline, data SimData, method string, blockDays int, r *rand.Rand) SimData {
+	order := make([]int, 0, len(days))
+	if method == "shuffle" {
+		order = r.Perm(len(days))
+	} else {
+		if blockDays > len(days) {
+			blockDays = len(days)
+		}
+		for len(order) < len(days) {
+			start := r.Intn(len(days) - blockDays + 1)
+			for i := start; i < start+blockDays && len(order) < len(days); i++ {
+				order = append(order, i)
+			}
+		}
+	}
+
+	preClose := data.PreClose
+	path := SimData{PreClose: preClose, Source: data.Source}
+	price := preClose
+	for slot, src := range order {
+		// The close the source day moved from
+		ref := preClose
+		if src > 0 {
+			prev := days[src-1]
+			ref = prev[len(prev)-1].Close
+		}
+		scale := price / ref
+		// Days to move the source bars by; a session may run past midnight
+		shift := barTime(days[slot][0].Timestamp[:10]).Sub(barTime(days[src][0].Timestamp[:10]))
+		for _, k := range days[src] {
+			k.Timestamp = barTime(k.Timestamp[:10]).Add(shift).Format("2006-01-02") + k.Timestamp[10:]
+			k.Open *= scale
+			k.High *= scale
+			k.Low *= scale
+			k.Close *= scale
+			path.Klines = append(path.Klines, k)
+		}
+		price = path.Klines[len(path.Klines)-1].Close
+	}
+	return path
+}
+
+// distribution returns the summary statistics of values.
+func distribution(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mean := Mean(sorted)
+	return Distribution{
+		Mean:   RoundTo3(mean),
+		StdDev: RoundTo3(StdDev(sorted, mean)),
+		Min:    sorted[0],
+		P5:     RoundTo3(percentile(sorted, 5)),
+		P25:    RoundTo3(percentile(sorted, 25)),
+		P50:    RoundTo3(percentile(sorted, 50)),
+		P75:    RoundTo3(percentile(sorted, 75)),
+		P95:    RoundTo3(percentile(sorted, 95)),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// percentile interpolates the p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (sorted[lo+1]-sorted[lo])*(pos-float64(lo))
+}
@@ -0,0 +1,80 @@
+package main
+
+import "fmt"
+
+// orderTypes lists the supported SimConfig.OrderType values: how a grid level that
+// the price reaches turns into a fill. Exit orders keep their own prices.
+var orderTypes = map[string]bool{
+	"":       true, // Fill at the level on the bar that reaches it, with slippage
+	"limit":  true, // Resting limit orders placed after the previous fill: no slippage, a gap fills at the open
+	"market": true, // Market-on-trigger: the level triggers a market order, filled at the level or the gapped open, with slippage
+}
+
+func validateOrderModel(config SimConfig) error {
+	if !orderTypes[config.OrderType] {
+		return fmt.Errorf("unknown order type: %s", config.OrderType)
+	}
+	if config.OrderDelayBars < 0 || config.OrderDelaySeconds < 0 {
+		return fmt.Errorf("orderDelayBars and orderDelaySeconds must not be negative")
+	}
+	if (config.OrderDelayBars > 0 || config.OrderDelaySeconds > 0) && config.OrderType == "" {
+		return fmt.Errorf("orderDelayBars and orderDelaySeconds need orderType limit or market")
+	}
+	return nil
+}
+
+// orderActive reports whether the grid's orders, placed when the last grid order
+// filled, are working on the current bar. Before the first fill they all are.
+func (e *simEngine) orderActive() bool {
+	if e.placedBar < 0 {
+		return true
+	}
+	if e.barIndex-e.placedBar < e.config.OrderDelayBars {
+		return false
+	}
+	if e.config.OrderDelaySeconds > 0 {
+		elapsed := barTime(e.bar.Timestamp).Sub(barTime(e.placedTime)).Seconds()
+		return elapsed >= float64(e.config.OrderDelaySeconds)
+	}
+	return true
+}
+
+// executionPrice is where a BUY or SELL of o fills, slippage included, and whether it
+// fills at a gapped open: under an order type, an order already working when the bar
+// opened through its level fills at the open, and so does every further level the
+// same gap crosses.
+func (e *simEngine) executionPrice(o Order) (float64, bool) {
+	slippage := e.config.SlippageRate
+	gapped := false
+	if o.Reason == "" && e.config.OrderType != "" {
+		if e.placedBar < e.barIndex || e.openFill {
+			gapped = (o.Side == "BUY" && e.bar.Open < o.Price) || (o.Side == "SELL" && e.bar.Open > o.Price)
+			if gapped {
+				o.Price = e.bar.Open
+			}
+		}
+		if e.config.OrderType == "limit" {
+			slippage = 0
+		}
+	}
+	if o.Side == "BUY" {
+		return o.Price * (1 + slippage), gapped
+	}
+	return o.Price * (1 - slippage), gapped
+}
+
+// filledAtOpen records a fill at a gapped open.
+func (e *simEngine) filledAtOpen() {
+	e.openFill = true
+	e.result.GapFills++
+}
+
+// placeOrders records that a grid order filled on the current bar: the grid's next
+// orders are placed now and start working after the configured delay.
+func (e *simEngine) placeOrders(o Order) {
+	if o.Reason != "" {
+		return
+	}
+	e.placedBar = e.barIndex
+	e.placedTime = e.bar.Timestamp
+}
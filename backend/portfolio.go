@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PortfolioConfig runs several grids from one account.
+type PortfolioConfig struct {
+	InitialCapital float64     `json:"initialCapital"` // Shared cash pool
+	StartDate      string      `json:"startDate"`      // Used by legs without their own StartDate
+	EndDate        string      `json:"endDate"`        // Used by legs without their own EndDate
+	Legs           []SimConfig `json:"legs"`           // A leg's InitialCapital is its allocation (default: equal split)
+}
+
+// cashPool is the cash shared by the legs of a portfolio run.
+type cashPool struct {
+	cash float64
+	min  float64 // Lowest balance reached
+}
+
+func (p *cashPool) add(amount float64) {
+	p.cash += amount
+	if p.cash < p.min {
+		p.min = p.cash
+	}
+}
+
+type PortfolioPoint struct {
+	Date   string    `json:"date"`
+	Equity float64   `json:"equity"` // Pool cash plus every leg's holdings at the close
+	Cash   float64   `json:"cash"`   // Pool balance at the end of the day
+	Legs   []float64 `json:"legs"`   // Each leg's equity: allocation plus its PnL, in Legs order
+}
+
+type PortfolioLeg struct {
+	Symbol           string    `json:"symbol"`
+	Allocation       float64   `json:"allocation"`
+	ContentionMisses int       `json:"contentionMisses"` // Missed buys this leg could have afforded on its own allocation
+	Result           SimResult `json:"result"`
+}
+
+type PortfolioResult struct {
+	InitialCapital   float64          `json:"initialCapital"`
+	FinalEquity      float64          `json:"finalEquity"`
+	TotalReturn      float64          `json:"totalReturn"` // %
+	MaxDrawdown      float64          `json:"maxDrawdown"` // %, of the aggregate equity
+	MinPoolCash      float64          `json:"minPoolCash"`
+	MissedBuys       int              `json:"missedBuys"`
+	ContentionMisses int              `json:"contentionMisses"` // Missed buys caused by other legs
+	Symbols          []string         `json:"symbols"`
+	Correlation      [][]float64      `json:"correlation"` // Of daily leg returns, in Symbols order
+	Equity           []PortfolioPoint `json:"equity"`
+	Legs             []PortfolioLeg   `json:"legs"`
+}
+
+func runPortfolioSimulation(c *gin.Context) {
+	var config PortfolioConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if config.InitialCapital <= 0 || len(config.Legs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "initialCapital and at least one leg are required"})
+		return
+	}
+
+	legs, err := portfolioLegs(config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := make([]SimData, len(legs))
+	for i, leg := range legs {
+		if data[i], err = getSimulationData(leg); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, calcPortfolio(data, legs, config.InitialCapital))
+}
+
+// portfolioLegs applies the single-run defaults to every leg and splits the capital
+// equally among legs without an allocation.
+func portfolioLegs(config PortfolioConfig) ([]SimConfig, error) {
+	legs := make([]SimConfig, len(config.Legs))
+	allocated, unallocated := 0.0, 0
+	for i, leg := range config.Legs {
+		if leg.Symbol == "" {
+			return nil, fmt.Errorf("leg %d: symbol is required", i+1)
+		}
+		if leg.StartDate == "" {
+			leg.StartDate = config.StartDate
+		}
+		if leg.EndDate == "" {
+			leg.EndDate = config.EndDate
+		}
+		if leg.GridStep <= 0 {
+			leg.GridStep = 1.0
+		}
+		if leg.AmountPerGrid <= 0 {
+			leg.AmountPerGrid = 100
+		}
+		if leg.InitialCapital > 0 {
+			allocated += leg.InitialCapital
+		} else {
+			unallocated++
+		}
+		legs[i] = leg
+	}
+	if allocated > config.InitialCapital+0.0001 {
+		return nil, fmt.Errorf("leg allocations (%.2f) exceed initialCapital", allocated)
+	}
+	for i := range legs {
+		if legs[i].InitialCapital <= 0 {
+			legs[i].InitialCapital = (config.InitialCapital - allocated) / float64(unallocated)
+		}
+		if err := validateSimConfig(legs[i]); err != nil {
+			return nil, fmt.Errorf("leg %d (%s): %v", i+1, legs[i].Symbol, err)
+		}
+	}
+	return legs, nil
+}
+
+// calcPortfolio runs every leg on one time-ordered bar stream. Each leg keeps its own
+// books, but buys draw on the shared pool, so a leg can be starved by the others.
+func calcPortfolio(data []SimData, legs []SimConfig, capital float64) PortfolioResult {
+	pool := &cashPool{cash: capital, min: capital}
+	engines := make([]*simEngine, len(legs))
+	type legBar struct {
+		leg int
+		at  time.Time // The bar's timestamp in its market's zone
+		k   Kline
+	}
+	var stream []legBar
+	for i := range legs {
+		e, err := newRun(data[i], legs[i])
+		if err != nil {
+			continue
+		}
+		e.pool = pool
+		engines[i] = e
+		loc := data[i].Source.Location()
+		for _, k := range data[i].Klines {
+			stream = append(stream, legBar{i, barTimeIn(k.Timestamp, loc), k})
+		}
+	}
+	// Markets store local times (UTC+8 for A-shares and HK, UTC for crypto), so bars are
+	// merged by the moment they stand for. Stable, so legs trading the same minute keep
+	// their request order.
+	sort.SliceStable(stream, func(i, j int) bool {
+		return stream[i].at.Before(stream[j].at)
+	})
+
+	poolCash := make(map[string]float64)
+	for _, b := range stream {
+		engines[b.leg].feed(b.k, data[b.leg])
+		poolCash[engines[b.leg].market.SessionDate(b.k.Timestamp)] = pool.cash
+	}
+
+	result := PortfolioResult{InitialCapital: capital, MinPoolCash: RoundTo3(pool.min)}
+	// Daily equity of each leg: its allocation plus the day-by-day PnL of its own run
+	legEquity := make([]map[string]float64, len(legs))
+	dateSet := make(map[string]bool)
+	idle := capital
+	for i, leg := range legs {
+		idle -= leg.InitialCapital
+		pl := PortfolioLeg{Symbol: leg.Symbol, Allocation: RoundTo3(leg.InitialCapital)}
+		legEquity[i] = make(map[string]float64)
+		if e := engines[i]; e != nil && len(data[i].Klines) > 0 {
+			pl.Result = e.finish(data[i].Klines, data[i].PreClose)
+			pl.Result.Halts = data[i].Halts
+			pl.Result.ChartData = nil
+			pl.ContentionMisses = e.contentionMisses
+			equity := leg.InitialCapital
+			for _, s := range pl.Result.DailyStats {
+				equity += s.NetProfit
+				legEquity[i][s.Date] = equity
+				dateSet[s.Date] = true
+			}
+		}
+		result.MissedBuys += pl.Result.MissedBuys
+		result.ContentionMisses += pl.ContentionMisses
+		result.Symbols = append(result.Symbols, leg.Symbol)
+		result.Legs = append(result.Legs, pl)
+	}
+
+	dates := make([]string, 0, len(dateSet))
+	for d := range dateSet {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+
+	// Legs that do not trade on a date (other market's holiday) carry their last equity forward
+	last := make([]float64, len(legs))
+	for i, leg := range legs {
+		last[i] = leg.InitialCapital
+	}
+	lastCash := capital
+	peak := capital
+	maxDrawdown := 0.0
+	returns := make([][]float64, len(legs))
+	for _, d := range dates {
+		p := PortfolioPoint{Date: d, Equity: idle}
+		for i := range legs {
+			eq, ok := legEquity[i][d]
+			if !ok {
+				eq = last[i]
+			}
+			if last[i] > 0 {
+				returns[i] = append(returns[i], eq/last[i]-1)
+			}
+			last[i] = eq
+			p.Equity += eq
+			p.Legs = append(p.Legs, RoundTo3(eq))
+		}
+		if cash, ok := poolCash[d]; ok {
+			lastCash = cash
+		}
+		p.Cash = RoundTo3(lastCash)
+		peak = math.Max(peak, p.Equity)
+		maxDrawdown = math.Max(maxDrawdown, (peak-p.Equity)/peak)
+		p.Equity = RoundTo3(p.Equity)
+		result.Equity = append(result.Equity, p)
+	}
+
+	result.FinalEquity = capital
+	if n := len(result.Equity); n > 0 {
+		result.FinalEquity = result.Equity[n-1].Equity
+	}
+	result.TotalReturn = RoundTo3((result.FinalEquity/capital - 1) * 100)
+	result.MaxDrawdown = RoundTo3(maxDrawdown * 100)
+	result.Correlation = correlationMatrix(returns)
+	return result
+}
+
+// correlationMatrix returns the pairwise Pearson correlation of equally long series.
+func correlationMatrix(series [][]float64) [][]float64 {
+	n := len(series)
+	out := make([][]float64, n)
+	for i := range out {
+		out[i] = make([]float64, n)
+		for j := range out[i] {
+			if i == j {
+				out[i][j] = 1
+				continue
+			}
+			out[i][j] = RoundTo3(correlation(series[i], series[j]))
+		}
+	}
+	return out
+}
+
+func correlation(a, b []float64) float64 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	if n < 2 {
+		return 0
+	}
+	var meanA, meanB float64
+	for i := 0; i < n; i++ {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= float64(n)
+	meanB /= float64(n)
+	var cov, varA, varB float64
+	for i := 0; i < n; i++ {
+		da, db := a[i]-meanA, b[i]-meanB
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+	if varA == 0 || varB == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varA*varB)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// PriceLimit is the daily band an A-share symbol may trade in.
+type PriceLimit struct {
+	Up   float64 `json:"up"`
+	Down float64 `json:"down"`
+}
+
+// HaltEvent is a trading day on which the simulation could not trade the symbol.
+type HaltEvent struct {
+	Date   string `json:"date"`
+	Reason string `json:"reason"` // "suspended" (zero-volume daily bar) | "no_intraday_data"
+}
+
+// BlockedEvent is a grid order that could not fill because the price was locked at a limit.
+type BlockedEvent struct {
+	Time   string  `json:"time"`
+	Side   string  `json:"side"`
+	Price  float64 `json:"price"`
+	Reason string  `json:"reason"` // "limit_up" | "limit_down"
+}
+
+// isAShareFund reports exchange-traded funds (ETF / LOF), which trade in 0.001 ticks.
+func isAShareFund(symbol string) bool {
+	for _, p := range []string{"15", "16", "18", "50", "51", "52", "56", "58"} {
+		if strings.HasPrefix(symbol, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// aShareTick is the minimum price increment of an A-share symbol.
+func aShareTick(symbol string) float64 {
+	if isAShareFund(symbol) {
+		return 0.001
+	}
+	return 0.01
+}
+
+// Dates the limit rules changed on.
+const (
+	chiNextReformDate = "2020-08-24" // ChiNext (and its ETFs) from 10% to 20%, ST included
+	mainBoardSTDate   = "2025-07-07" // Main-board ST stocks from 5% to 10%
+)
+
+// chiNextFunds are ChiNext-only ETFs on the Shenzhen exchange, which follow the ChiNext band.
+var chiNextFunds = map[string]bool{
+	"159915": true, // ChiNext
+	"159949": true, // ChiNext 50
+	"159952": true, // ChiNext ETF (GF)
+	"159948": true, // ChiNext (Southern)
+	"159977": true, // ChiNext (Tianhong)
+}
+
+// priceLimitPct returns the daily limit in percent for a symbol's board on date, 0
+// when the market has no limit. name is the display name, used to spot ST stocks; it is
+// today's name, so a stock that was ST in the past is not recognized on those dates.
+func priceLimitPct(symbol, name, date string) float64 {
+	if detectMarket(symbol) != MarketAShare {
+		return 0
+	}
+	st := strings.Contains(strings.ToUpper(name), "ST")
+	switch {
+	case strings.HasPrefix(symbol, "588"):
+		return 20 // STAR Market ETFs
+	case chiNextFunds[symbol]:
+		if date < chiNextReformDate {
+			return 10
+		}
+		return 20
+	case isAShareFund(symbol):
+		return 10
+	case strings.HasPrefix(symbol, "688") || strings.HasPrefix(symbol, "689"):
+		return 20 // STAR Market, ST included
+	case strings.HasPrefix(symbol, "300") || strings.HasPrefix(symbol, "301"):
+		if date >= chiNextReformDate {
+			return 20
+		}
+		if st {
+			return 5
+		}
+		return 10
+	case strings.HasPrefix(symbol, "8") || strings.HasPrefix(symbol, "4") || strings.HasPrefix(symbol, "92"):
+		return 30 // Beijing Stock Exchange
+	case st && date < mainBoardSTDate:
+		return 5
+	}
+	return 10
+}
+
+// buildPriceLimits derives each day's band from the previous traded close and
+// flags suspended days. daily must be sorted; preClose is the close before daily[0].
+func buildPriceLimits(daily []Kline, preClose float64, limitPct func(date string) float64, tick float64) (map[string]PriceLimit, []HaltEvent) {
+	limits := make(map[string]PriceLimit)
+	var halts []HaltEvent
+	prev := preClose
+	for _, d := range daily {
+		if len(d.Timestamp) < 10 {
+			continue
+		}
+		date := d.Timestamp[:10]
+		if d.Volume == 0 {
+			// A suspended day keeps the last traded close as the reference for the next one
+			halts = append(halts, HaltEvent{Date: date, Reason: "suspended"})
+			continue
+		}
+		if pct := limitPct(date); pct > 0 && prev > 0 {
+			limits[date] = PriceLimit{
+				Up:   math.Round(prev*(1+pct/100)/tick) * tick,
+				Down: math.Round(prev*(1-pct/100)/tick) * tick,
+			}
+		}
+		prev = d.Close
+	}
+	return limits, halts
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestPriceLimitPct(t *testing.T) {
+	cases := []struct {
+		symbol, name, date string
+		want               float64
+	}{
+		{"600519", "Kweichow Moutai", "2024-01-02", 10}, // Shanghai main board
+		{"000001", "Ping An Bank", "2024-01-02", 10},    // Shenzhen main board
+		{"600000", "*ST Demo", "2024-01-02", 5},         // Main-board ST
+		{"600000", "*ST Demo", "2025-07-07", 10},        // Main-board ST after the 2025 change
+		{"688981", "SMIC", "2024-01-02", 20},            // STAR Market
+		{"688001", "ST Demo", "2024-01-02", 20},         // STAR Market ST follows the board
+		{"300750", "CATL", "2020-08-21", 10},            // ChiNext before its reform
+		{"300750", "CATL", "2020-08-24", 20},            // ChiNext after it
+		{"300001", "ST Demo", "2020-08-21", 5},          // ChiNext ST before the reform
+		{"300001", "ST Demo", "2024-01-02", 20},         // ChiNext ST after it
+		{"830799", "Demo", "2024-01-02", 30},            // Beijing Stock Exchange
+		{"920001", "Demo", "2024-01-02", 30},
+		{"510300", "CSI 300 ETF", "2024-01-02", 10}, // Shanghai ETF
+		{"512890", "Dividend ETF", "2024-01-02", 10},
+		{"588000", "STAR 50 ETF", "2024-01-02", 20}, // STAR ETF
+		{"159915", "ChiNext ETF", "2020-08-21", 10}, // ChiNext ETF before the reform
+		{"159915", "ChiNext ETF", "2024-01-02", 20}, // and after it
+		{"159919", "CSI 300 ETF", "2024-01-02", 10}, // Other Shenzhen ETFs
+		{"00700", "Tencent", "2024-01-02", 0},       // HK has no limit
+		{"BTCUSDT", "Bitcoin", "2024-01-02", 0},     // Nor does crypto
+	}
+	for _, tc := range cases {
+		if got := priceLimitPct(tc.symbol, tc.name, tc.date); got != tc.want {
+			t.Errorf("%s %q on %s: got %v%%, want %v%%", tc.symbol, tc.name, tc.date, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import "sort"
+
+// PathLeg is one directional sweep inside a bar: a "B" leg walks the price down to
+// Price (triggering buys), an "S" leg walks it up to Price (triggering sells).
+type PathLeg struct {
+	Side  string
+	Price float64
+}
+
+// Bar is a kline together with the intra-bar price path the engine assumes for it.
+type Bar struct {
+	Kline
+	Path []PathLeg
+}
+
+// pathModels lists the supported intra-bar orderings for SimConfig.PathModel.
+var pathModels = []string{
+	"legacy",      // Sell first on down bars (Open > Close), buy first otherwise
+	"ohlc",        // Open -> High -> Low -> Close
+	"olhc",        // Open -> Low -> High -> Close
+	"nearest",     // Visit the extreme closest to Open first, then the other, then Close
+	"pessimistic", // Shortest path: nearest extreme, then the other, no retrace to Close (fewest grid crossings)
+	"refine",      // Follow the 1m bars inside each 5m bar, "nearest" where none are stored
+}
+
+func isPathModel(model string) bool {
+	if model == "" {
+		return true
+	}
+	for _, m := range pathModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// barPath returns the legs a bar is assumed to travel through under model.
+// sub holds the finer bars inside k and is only used by "refine".
+func barPath(model string, k Kline, sub []Kline) []PathLeg {
+	switch model {
+	case "ohlc":
+		return []PathLeg{{"S", k.High}, {"B", k.Low}, {"S", k.Close}}
+	case "olhc":
+		return []PathLeg{{"B", k.Low}, {"S", k.High}, {"B", k.Close}}
+	case "nearest":
+		if k.High-k.Open <= k.Open-k.Low {
+			return barPath("ohlc", k, nil)
+		}
+		return barPath("olhc", k, nil)
+	case "pessimistic":
+		// The grid earns on every level it crosses, so the worst case is the least travel
+		if k.High-k.Open <= k.Open-k.Low {
+			return []PathLeg{{"S", k.High}, {"B", k.Low}}
+		}
+		return []PathLeg{{"B", k.Low}, {"S", k.High}}
+	case "refine":
+		if len(sub) == 0 {
+			return barPath("nearest", k, nil)
+		}
+		var path []PathLeg
+		for _, s := range sub {
+			path = append(path, barPath("nearest", s, nil)...)
+		}
+		return path
+	}
+
+	if k.Open > k.Close {
+		return []PathLeg{{"S", k.High}, {"B", k.Low}}
+	}
+	return []PathLeg{{"B", k.Low}, {"S", k.High}}
+}
+
+// attachSubBars keeps every 5m bar as the primary series and hangs the 1m bars of
+// its window underneath it. Dates without any 5m data fall back to the 1m bars themselves.
+func attachSubBars(klines1m, klines5m []Kline) ([]Kline, map[string][]Kline) {
+	datesWith5m := make(map[string]bool)
+	for _, k := range klines5m {
+		if len(k.Timestamp) >= 10 {
+			datesWith5m[k.Timestamp[:10]] = true
+		}
+	}
+
+	var klines []Kline
+	klines = append(klines, klines5m...)
+	subBars := make(map[string][]Kline)
+
+	// Both series are sorted; a 5m bar stamped T covers the 1m bars in (T-5m, T]
+	j := 0
+	for _, k := range klines1m {
+		if len(k.Timestamp) < 10 || !datesWith5m[k.Timestamp[:10]] {
+			klines = append(klines, k)
+			continue
+		}
+		for j < len(klines5m) && klines5m[j].Timestamp < k.Timestamp {
+			j++
+		}
+		if j < len(klines5m) && klines5m[j].Timestamp[:10] == k.Timestamp[:10] {
+			subBars[klines5m[j].Timestamp] = append(subBars[klines5m[j].Timestamp], k)
+		}
+	}
+
+	sort.Slice(klines, func(i, j int) bool {
+		return klines[i].Timestamp < klines[j].Timestamp
+	})
+	return klines, subBars
+}
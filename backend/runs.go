@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// engineVersion is stored with every run so results computed by different engine
+// logic are not compared unknowingly. Bump it whenever testdata/golden changes.
+const engineVersion = 1
+
+// maxCompareRuns caps the runs one compare request may load.
+const maxCompareRuns = 10
+
+// SimulationRun is a saved /api/simulate run: its config, the summary metrics for
+// listing and the full SimResult, gzip-compressed.
+type SimulationRun struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	Symbol        string          `gorm:"index" json:"symbol"`
+	Name          string          `json:"name"`
+	Tags          string          `json:"tags"` // Comma-separated, e.g. "baseline,hk"
+	EngineVersion int             `json:"engineVersion"`
+	Config        json.RawMessage `json:"config"` // SimConfig as submitted
+
+	// Summary of the result
+	TotalProfit      float64 `json:"totalProfit"`
+	TotalYieldAmount float64 `json:"totalYieldAmount"`
+	PeriodReturn     float64 `json:"periodReturn"`
+	MaxDrawdown      float64 `json:"maxDrawdown"`
+	SharpeRatio      float64 `json:"sharpeRatio"`
+	CAGR             float64 `json:"cagr"`
+	WinRate          float64 `json:"winRate"`
+	TotalTx          int     `json:"totalTx"`
+
+	Result []byte `json:"-"` // Gzip-compressed SimResult JSON
+}
+
+func (SimulationRun) TableName() string {
+	return "simulation_runs"
+}
+
+// RunDetail is a saved run together with its full result.
+type RunDetail struct {
+	SimulationRun
+	Result json.RawMessage `json:"result"`
+}
+
+type EquityPoint struct {
+	Date   string  `json:"date"`
+	Equity float64 `json:"equity"`
+}
+
+type CompareRun struct {
+	SimulationRun
+	Equity []EquityPoint `json:"equity"` // Daily strategy equity, from DailyStat.Equity
+}
+
+// RunComparison lines runs up side by side. Differences lists the config fields
+// (JSON names) whose values are not the same in every run.
+type RunComparison struct {
+	Runs        []CompareRun `json:"runs"`
+	Differences []string     `json:"differences"`
+}
+
+// saveRun stores a finished run of config.
+func saveRun(config SimConfig, result SimResult, name, tags string) (SimulationRun, error) {
+	conf, err := json.Marshal(config)
+	if err != nil {
+		return SimulationRun{}, err
+	}
+	full, err := json.Marshal(result)
+	if err != nil {
+		return SimulationRun{}, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(full); err != nil {
+		return SimulationRun{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return SimulationRun{}, err
+	}
+
+	run := SimulationRun{
+		Symbol:           config.Symbol,
+		Name:             name,
+		Tags:             normalizeTags(strings.Split(tags, ",")),
+		EngineVersion:    engineVersion,
+		Config:           conf,
+		TotalProfit:      result.TotalProfit,
+		TotalYieldAmount: result.TotalYieldAmount,
+		PeriodReturn:     result.PeriodReturn,
+		MaxDrawdown:      result.MaxDrawdown,
+		SharpeRatio:      result.SharpeRatio,
+		CAGR:             result.CAGR,
+		WinRate:          result.WinRate,
+		TotalTx:          result.TotalTx,
+		Result:           buf.Bytes(),
+	}
+	err = DB.Create(&run).Error
+	return run, err
+}
+
+// normalizeTags trims tags, drops empty and duplicate ones and joins them sorted.
+func normalizeTags(tags []string) string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+// fullResult decompresses the stored SimResult JSON.
+func (r SimulationRun) fullResult() (json.RawMessage, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(r.Result))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// likeEscaper escapes the LIKE wildcards in a value matched literally, with \ as the
+// ESCAPE character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func RegisterRunRoutes(r *gin.Engine) {
+	// GET /api/runs?symbol=512890&tag=baseline - Newest first, without the full results
+	r.GET("/api/runs", func(c *gin.Context) {
+		query := DB.Omit("result").Order("id desc")
+		if symbol := c.Query("symbol"); symbol != "" {
+			query = query.Where("symbol = ?", symbol)
+		}
+		if tag := c.Query("tag"); tag != "" {
+			query = query.Where(`',' || tags || ',' LIKE ? ESCAPE '\'`, "%,"+likeEscaper.Replace(tag)+",%")
+		}
+		var runs []SimulationRun
+		if err := query.Find(&runs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": runs})
+	})
+
+	// GET /api/runs/compare?ids=3,7,8
+	r.GET("/api/runs/compare", func(c *gin.Context) {
+		var ids []uint
+		for _, s := range strings.Split(c.Query("ids"), ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid run id %q", s)})
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+		if len(ids) < 2 || len(ids) > maxCompareRuns {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("compare needs 2 to %d run ids", maxCompareRuns)})
+			return
+		}
+		comparison, err := compareRuns(ids)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, comparison)
+	})
+
+	// GET /api/runs/:id - The run with its full result
+	r.GET("/api/runs/:id", func(c *gin.Context) {
+		id, ok := runID(c)
+		if !ok {
+			return
+		}
+		var run SimulationRun
+		if err := DB.First(&run, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+			return
+		}
+		result, err := run.fullResult()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, RunDetail{SimulationRun: run, Result: result})
+	})
+
+	// PATCH /api/runs/:id - Rename and/or re-tag: {"name": "...", "tags": ["a", "b"]}
+	r.PATCH("/api/runs/:id", func(c *gin.Context) {
+		id, ok := runID(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Name *string  `json:"name"`
+			Tags []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var run SimulationRun
+		if err := DB.Omit("result").First(&run, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+			return
+		}
+		updates := map[string]interface{}{}
+		if req.Name != nil {
+			run.Name = *req.Name
+			updates["name"] = run.Name
+		}
+		if req.Tags != nil {
+			run.Tags = normalizeTags(req.Tags)
+			updates["tags"] = run.Tags
+		}
+		if len(updates) > 0 {
+			if err := DB.Model(&SimulationRun{}).Where("id = ?", run.ID).Updates(updates).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"data": run})
+	})
+
+	// DELETE /api/runs/:id
+	r.DELETE("/api/runs/:id", func(c *gin.Context) {
+		id, ok := runID(c)
+		if !ok {
+			return
+		}
+		res := DB.Delete(&SimulationRun{}, id)
+		if res.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+			return
+		}
+		if res.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Run removed"})
+	})
+}
+
+// runID parses the :id parameter, answering 400 when it is not a run id.
+func runID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid run id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// compareRuns loads the runs in ids order with their equity curves and finds the config
+// fields they differ in.
+func compareRuns(ids []uint) (RunComparison, error) {
+	var comparison RunComparison
+	var configs []map[string]interface{}
+	for _, id := range ids {
+		var run SimulationRun
+		if err := DB.First(&run, id).Error; err != nil {
+			return RunComparison{}, fmt.Errorf("run %d not found", id)
+		}
+		raw, err := run.fullResult()
+		if err != nil {
+			return RunComparison{}, err
+		}
+		var result struct {
+			DailyStats []DailyStat `json:"dailyStats"`
+		}
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return RunComparison{}, err
+		}
+		cr := CompareRun{SimulationRun: run}
+		for _, s := range result.DailyStats {
+			cr.Equity = append(cr.Equity, EquityPoint{Date: s.Date, Equity: s.Equity})
+		}
+		comparison.Runs = append(comparison.Runs, cr)
+
+		var conf map[string]interface{}
+		if err := json.Unmarshal(run.Config, &conf); err != nil {
+			return RunComparison{}, err
+		}
+		configs = append(configs, conf)
+	}
+
+	fields := make(map[string]bool)
+	for _, conf := range configs {
+		for k := range conf {
+			fields[k] = true
+		}
+	}
+	for field := range fields {
+		for _, conf := range configs[1:] {
+			if !reflect.DeepEqual(conf[field], configs[0][field]) {
+				comparison.Differences = append(comparison.Differences, field)
+				break
+			}
+		}
+	}
+	sort.Strings(comparison.Differences)
+	return comparison, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"math"
 	"net/http"
@@ -11,7 +12,8 @@ import (
 )
 
 type SimConfig struct {
-	Symbol         string  `json:"symbol"` // New: Stock Symbol
+	Symbol         string  `json:"symbol"`   // New: Stock Symbol
+	Strategy       string  `json:"strategy"` // Strategy name, see strategyRegistry ("grid" by default)
 	StartDate      string  `json:"startDate"`
 	BasePrice      float64 `json:"basePrice"`
 	GridStep       float64 `json:"gridStep"`       // e.g. 1.0 for 1% OR 0.05 for Price
@@ -23,50 +25,222 @@ type SimConfig struct {
 	InitialShares  int64   `json:"initialShares"`  // Base Position
 	InitialCapital float64 `json:"initialCapital"` // Fixed base capital (0 = disabled/infinite)
 	UsePenetration bool    `json:"usePenetration"` // New: Strict penetration mode
+	PathModel      string  `json:"pathModel"`      // Intra-bar price path, see pathModels ("legacy" by default)
+	PathReport     bool    `json:"pathReport"`     // Also rerun under every path model, see SimResult.PathSensitivity
+	LotMatching    string  `json:"lotMatching"`    // Which lots a sell closes: "grid" (default) | "fifo" | "lifo"
+	Settlement     string  `json:"settlement"`     // "" (by market) | "T+0" | "T+1"
+	PriceLimitPct  float64 `json:"priceLimitPct"`  // Override the board's daily limit in percent (0 = by board)
+	IgnoreLimits   bool    `json:"ignoreLimits"`   // Fill at locked limits and trade through halts as before
+	FeeModel       string  `json:"feeModel"`       // Cost schedule, see feeModels ("" = by market)
+	BNBDiscount    bool    `json:"bnbDiscount"`    // "binance": fees paid in BNB (25% off)
+
+	// Corporate actions: dividends and splits are always booked; this also moves the grid
+	AdjustGridOnExDate bool `json:"adjustGridOnExDate"` // Shift the base price to the ex-rights price on ex-dates
+
+	// Backtest window: StartDate onwards, narrowed by these (empty = no limit)
+	EndDate      string      `json:"endDate"`      // Last date traded
+	DateRanges   []DateRange `json:"dateRanges"`   // Only trade on dates inside one of these ranges
+	ExcludeDates []string    `json:"excludeDates"` // Dates to skip, e.g. a day with corrupt data
+
+	// Benchmark: buy-and-hold of the same capital, see DailyStat.BenchmarkEquity
+	BenchmarkSymbol string `json:"benchmarkSymbol"` // Stored symbol to compare against, e.g. an index ETF (default: the traded symbol)
+
+	// Order sizing: units of the amounts below and the market's quantity rules
+	SizingUnit  string  `json:"sizingUnit"`  // "" (shares) | "value" (currency per order, e.g. 5000)
+	LotRules    bool    `json:"lotRules"`    // Round orders to the market's lot rules (always on for "value")
+	LotSize     float64 `json:"lotSize"`     // Override the lot / board lot / LOT_SIZE step
+	MinNotional float64 `json:"minNotional"` // Override the minimum order value
+
+	// Liquidity: fills are capped by the bar's traded volume
+	MaxVolumePct float64 `json:"maxVolumePct"` // Fill at most this % of a bar's volume; the rest rests until the session ends (0 = unlimited)
+
+	// Order model: how a triggered level becomes a fill (see orderTypes)
+	OrderType         string `json:"orderType"`         // "" (at the level) | "limit" | "market"
+	OrderDelayBars    int    `json:"orderDelayBars"`    // Bars after a fill before the next orders are working
+	OrderDelaySeconds int    `json:"orderDelaySeconds"` // Seconds of bar time after a fill before the next orders are working
+
+	// Asymmetric grid: buy and sell with different steps (GridStepType units) and sizes
+	BuyStep    float64 `json:"buyStep"`    // Spacing of buy levels (0 = GridStep)
+	SellStep   float64 `json:"sellStep"`   // Each lot sells this far above its buy level (0 = one level)
+	BuyAmount  float64 `json:"buyAmount"`  // Shares per buy (0 = AmountPerGrid)
+	SellAmount float64 `json:"sellAmount"` // Shares per sell (0 = the whole lot); the rest sells one more SellStep up
+
+	// Price ladder: where the grid levels sit (see ladderTypes)
+	LadderType     string    `json:"ladderType"`     // "" (arithmetic) | "geometric" | "dense" | "log" | "explicit"
+	LadderLevels   []float64 `json:"ladderLevels"`   // "explicit": uploaded level prices
+	LadderMin      float64   `json:"ladderMin"`      // "log": lowest level
+	LadderMax      float64   `json:"ladderMax"`      // "log": highest level
+	LadderCount    int       `json:"ladderCount"`    // "log": number of levels
+	LadderExponent float64   `json:"ladderExponent"` // "dense": growth of the gaps away from base (default 1.5)
+	SnapToTick     bool      `json:"snapToTick"`     // Round levels to the symbol's tick size
+
+	// Price bounds and exits (0 = off). See boundPolicies for what happens outside the bounds.
+	UpperBound    float64 `json:"upperBound"`
+	LowerBound    float64 `json:"lowerBound"`
+	BoundPolicy   string  `json:"boundPolicy"`   // "" (pause) | "reenter" | "liquidate" | "stop_loss" | "take_profit"
+	StopLossPct   float64 `json:"stopLossPct"`   // Sell everything and stop once equity is down this many percent
+	TakeProfitPct float64 `json:"takeProfitPct"` // Sell everything and stop once equity is up this many percent
+
+	// Trailing grid: move the base price with the market (see trailingModes)
+	TrailingMode        string `json:"trailingMode"`        // "" (fixed) | "ma" | "range" | "shift"
+	TrailingMAPeriod    int    `json:"trailingMAPeriod"`    // "ma": moving average length in bars (default 240)
+	TrailingRangeLevels int    `json:"trailingRangeLevels"` // "range"/"shift": levels on each side of base that count as in range (default 5)
+	TrailingBars        int    `json:"trailingBars"`        // "range": consecutive out-of-range bars before re-centering (default 30)
+
+	// Position sizing below base (see sizingModes). Sells unwind the size of the lot they close.
+	SizingMode       string    `json:"sizingMode"`       // "" (flat) | "multiplier" | "table"
+	SizingMultiplier float64   `json:"sizingMultiplier"` // "multiplier": growth per level, e.g. 2 for 1x, 2x, 4x
+	SizingTable      []float64 `json:"sizingTable"`      // "table": shares for the 1st, 2nd, ... level below base
 }
 
 type DailyStat struct {
-	Date           string  `json:"date"`
-	BuyCount       int     `json:"buyCount"`
-	SellCount      int     `json:"sellCount"`
-	GrossProfit    float64 `json:"grossProfit"` // Realized profit
-	Commission     float64 `json:"commission"`
-	Value          float64 `json:"value"` // Market Value of Position (Initial + Net)
-	RealizedProfit float64 `json:"realizedProfit"`
-	NetProfit      float64 `json:"netProfit"` // Daily M2M PnL
-	ClosePrice     float64 `json:"closePrice"`
-	NetValue       float64 `json:"netValue"` // Daily Net Asset Value (Cash + Stock Market Value)
+	Date           string       `json:"date"`
+	BuyCount       int          `json:"buyCount"`
+	SellCount      int          `json:"sellCount"`
+	GrossProfit    float64      `json:"grossProfit"` // Realized profit
+	Commission     float64      `json:"commission"`  // All trading costs of the day
+	Fees           FeeBreakdown `json:"fees"`
+	Value          float64      `json:"value"` // Market Value of Position (Initial + Net)
+	RealizedProfit float64      `json:"realizedProfit"`
+	NetProfit      float64      `json:"netProfit"` // Daily M2M PnL
+	ClosePrice     float64      `json:"closePrice"`
+	NetValue       float64      `json:"netValue"` // Daily Net Asset Value (Cash + Stock Market Value)
+	Dividend       float64      `json:"dividend"` // Cash dividend credited on the ex-date
+
+	// Strategy and benchmark side by side
+	Equity          float64 `json:"equity"`          // Strategy equity at the close: Capital plus PnL so far
+	BenchmarkEquity float64 `json:"benchmarkEquity"` // Capital held in the benchmark since the previous close
+
+	initialShares float64 // Base position after any split, for the equity curve
+	benchGrowth   float64 // Growth of the own-symbol benchmark since the previous close
+	holdings      float64 // Shares held at the close
 }
 
 type Trade struct {
-	Time   string  `json:"time"`
-	Type   string  `json:"type"` // "BUY" | "SELL"
-	Price  float64 `json:"price"`
-	Amount float64 `json:"amount"`
-	Comm   float64 `json:"comm"`
+	Time   string       `json:"time"`
+	Type   string       `json:"type"` // "BUY" | "SELL"
+	Price  float64      `json:"price"`
+	Amount float64      `json:"amount"`
+	Comm   float64      `json:"comm"` // Total cost of the fill
+	Fees   FeeBreakdown `json:"fees"`
+	Reason string       `json:"reason"` // "grid" | "lower_bound" | "upper_bound" | "stop_loss" | "take_profit"
+
+	Requested float64 `json:"requested,omitempty"` // Order size when the bar's volume allowed only Amount
+	Resting   bool    `json:"resting,omitempty"`   // Fill of a resting remainder
 }
 
 type SimResult struct {
-	TotalProfit      float64       `json:"totalProfit"`
-	TotalYieldAmount float64       `json:"totalYieldAmount"` // Total Strategy PnL
-	TotalFloating    float64       `json:"totalFloating"`    // Floating PnL
-	TotalTx          int           `json:"totalTx"`
-	TotalComm        float64       `json:"totalComm"`
-	NetPosition      float64       `json:"netPosition"`
-	DailyStats       []DailyStat   `json:"dailyStats"`
-	Trades           []Trade       `json:"trades"`
-	ChartData        []Kline       `json:"chartData"`
-	GridDensityData  []GridDensity `json:"gridDensityData"`
-	MissedBuys       int           `json:"missedBuys"`  // Number of grid intervals skipped due to lack of cash
-	MissedSells      int           `json:"missedSells"` // Number of grid intervals skipped due to lack of inventory
+	TotalProfit      float64         `json:"totalProfit"`
+	TotalYieldAmount float64         `json:"totalYieldAmount"` // Total Strategy PnL
+	TotalFloating    float64         `json:"totalFloating"`    // Floating PnL
+	TotalTx          int             `json:"totalTx"`
+	TotalComm        float64         `json:"totalComm"`
+	FeeModel         string          `json:"feeModel"` // Cost schedule the run used
+	Fees             FeeBreakdown    `json:"fees"`
+	NetPosition      float64         `json:"netPosition"`
+	DailyStats       []DailyStat     `json:"dailyStats"`
+	Trades           []Trade         `json:"trades"`
+	ChartData        []Kline         `json:"chartData"`
+	GridDensityData  []GridDensity   `json:"gridDensityData"`
+	MissedBuys       int             `json:"missedBuys"`     // Number of grid intervals skipped due to lack of cash
+	MissedSells      int             `json:"missedSells"`    // Number of grid intervals skipped due to lack of inventory
+	RecenterEvents   []RecenterEvent `json:"recenterEvents"` // Base price moves made by a trailing grid
+
+	// Lot accounting: realized profit is measured against the actual lots a sell closes
+	LotMatching   string  `json:"lotMatching"`
+	OpenLots      []Lot   `json:"openLots"`
+	CostBasis     float64 `json:"costBasis"`     // Cost of the shares still held
+	UnrealizedPnL float64 `json:"unrealizedPnl"` // Open lots marked at the last close
+
+	// Lot rules: quantities rounded down to the market's trading unit
+	LotRule           LotRule         `json:"lotRule"`
+	RoundedOrders     int             `json:"roundedOrders"`     // Orders reduced by rounding
+	RoundingRemainder float64         `json:"roundingRemainder"` // Shares dropped by rounding in total
+	RejectedOrders    []RejectedOrder `json:"rejectedOrders"`    // Orders below the minimum after rounding
+
+	// Liquidity: orders cut short by MaxVolumePct and their resting remainders
+	PartialFills  int     `json:"partialFills"`  // Orders filled in part for lack of volume
+	RestingFills  int     `json:"restingFills"`  // Fills of resting remainders on later bars
+	ExpiredShares float64 `json:"expiredShares"` // Resting shares still unfilled when their session ended
+	FillRatio     float64 `json:"fillRatio"`     // % of the shares ordered that were filled
+
+	// Order model: fills at gapped opens and triggers on orders not yet working
+	GapFills       int `json:"gapFills"`       // Grid fills at an open that gapped through the level
+	InactiveOrders int `json:"inactiveOrders"` // Triggers ignored because the order was still within its delay
+
+	// Bounds and exits
+	ExitReason     string `json:"exitReason"`     // Why the run stopped early ("" = ran to the end)
+	ExitTime       string `json:"exitTime"`       // Bar the exit was triggered on
+	OutOfRangeBars int    `json:"outOfRangeBars"` // Bars that traded outside the bounds
+
+	// Corporate actions
+	Dividends        float64                `json:"dividends"` // Total cash dividends received
+	CorporateActions []CorporateActionEvent `json:"corporateActions"`
+
+	// Market the symbol trades on; DailyStats dates are its trading days
+	Market   string `json:"market"`   // "ashare" | "hk" | "crypto" | "gold"
+	Timezone string `json:"timezone"` // Zone of the bar timestamps
+
+	// Settlement: under T+1 shares bought in a session can only be sold from the next one
+	Settlement             string `json:"settlement"`             // Effective rule ("T+0" | "T+1")
+	SettlementBlockedSells int    `json:"settlementBlockedSells"` // Sells held back because the shares had not settled
+
+	// Daily price limits and suspensions
+	LimitBlockedBuys  int            `json:"limitBlockedBuys"`  // Sessions in which limit-up locked out a buy
+	LimitBlockedSells int            `json:"limitBlockedSells"` // Sessions in which limit-down locked out a sell
+	BlockedEvents     []BlockedEvent `json:"blockedEvents"`     // The first locked order of each side and session
+	Halts             []HaltEvent    `json:"halts"`
+
+	// Path sensitivity: the same run under every intra-bar path model (SimConfig.PathReport only)
+	PathSensitivity  []PathScenario `json:"pathSensitivity,omitempty"`
+	PathProfitSpread float64        `json:"pathProfitSpread"` // Max - min TotalYieldAmount across path models
+
+	RunID uint `json:"runId,omitempty"` // Id of the saved run when /api/simulate was called with save=true
 
 	// Advanced Metrics
 	MaxDrawdown     float64 `json:"maxDrawdown"` // Percentage (e.g., -0.15 for -15%)
 	SharpeRatio     float64 `json:"sharpeRatio"`
 	CAGR            float64 `json:"cagr"`            // Compound Annual Growth Rate
-	WinRate         float64 `json:"winRate"`         // Count of Profitable Grid Pairs / Total Completed Pairs
-	BenchmarkReturn float64 `json:"benchmarkReturn"` // Stock Price Change %
+	WinRate         float64 `json:"winRate"`         // % of round trips closed at a profit net of fees
+	BenchmarkReturn float64 `json:"benchmarkReturn"` // Stock Total Return % (price change plus dividends)
 	PeriodReturn    float64 `json:"periodReturn"`    // Un-annualized Strategy Return %
+	Capital         float64 `json:"capital"`         // Capital the returns are measured against (InitialCapital, else the peak cash used)
+
+	// Risk and exposure
+	SortinoRatio       float64 `json:"sortinoRatio"`
+	CalmarRatio        float64 `json:"calmarRatio"`        // CAGR / MaxDrawdown
+	MaxDrawdownDays    int     `json:"maxDrawdownDays"`    // Longest run of trading days below a previous equity peak
+	UlcerIndex         float64 `json:"ulcerIndex"`         // Root mean square of the daily drawdown, %
+	TimeInMarket       float64 `json:"timeInMarket"`       // % of trading days closed with shares held
+	CapitalUtilization float64 `json:"capitalUtilization"` // Average % of equity held in shares at the close
+	Turnover           float64 `json:"turnover"`           // Traded value (buys plus sells) / Capital
+
+	// Round trips: a sell closing a grid buy lot, or part of one
+	RoundTrips     int     `json:"roundTrips"`
+	AvgHoldingDays float64 `json:"avgHoldingDays"` // Calendar days from buy to sell
+
+	// Against the benchmark curve (daily returns, zero risk-free rate)
+	BenchmarkSymbol  string  `json:"benchmarkSymbol"`
+	ExcessReturn     float64 `json:"excessReturn"`     // PeriodReturn minus the benchmark's, %
+	TrackingError    float64 `json:"trackingError"`    // Annualized std of the daily excess returns, %
+	InformationRatio float64 `json:"informationRatio"` // Annualized mean / std of the daily excess returns
+	Alpha            float64 `json:"alpha"`            // Annualized Jensen's alpha, %
+	Beta             float64 `json:"beta"`
+}
+
+type PathScenario struct {
+	PathModel   string  `json:"pathModel"`
+	TotalProfit float64 `json:"totalProfit"` // TotalYieldAmount under this path model
+	GridProfit  float64 `json:"gridProfit"`  // Realized grid profit under this path model
+	TotalTx     int     `json:"totalTx"`
+}
+
+type RecenterEvent struct {
+	Time    string  `json:"time"`
+	Reason  string  `json:"reason"` // "ma" | "range" | "shift" | "reenter"
+	OldBase float64 `json:"oldBase"`
+	NewBase float64 `json:"newBase"`
+	Price   float64 `json:"price"` // Close of the bar that triggered the move
 }
 
 type GridDensity struct {
@@ -77,51 +251,183 @@ type GridDensity struct {
 func RegisterSimulationRoutes(r *gin.Engine) {
 	r.POST("/api/simulate", runSimulation)
 	r.POST("/api/simulate/batch", runBatchSimulation)
+	r.POST("/api/simulate/ladder", previewLadder)
+	r.POST("/api/simulate/portfolio", runPortfolioSimulation)
+	r.POST("/api/simulate/walkforward", runWalkForward)
+	r.POST("/api/simulate/sweep", runSweep)
+	r.POST("/api/simulate/montecarlo", runMonteCarlo)
+	r.GET("/api/simulate/strategies", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": strategyNames()})
+	})
 }
 
-func getSimulationData(symbol, startDate string) ([]Kline, float64, error) {
+// SimData is the market data one simulation runs against.
+type SimData struct {
+	Klines   []Kline
+	PreClose float64
+	SubBars  map[string][]Kline    // 1m bars inside each 5m bar, keyed by the 5m timestamp (PathModel "refine" only)
+	Limits   map[string]PriceLimit // Daily limit band by date (A-shares only)
+	Halts    []HaltEvent
+	Actions  []CorporateAction // Dividends and splits with ex-date in the window
+
+	Benchmark []BenchmarkPoint // Daily total-return closes of SimConfig.BenchmarkSymbol, if set
+	Source    MarketSource     // Where the bars came from; its session rules group them into days
+}
+
+func getSimulationData(config SimConfig) (SimData, error) {
+	symbol, startDate, endDate := config.Symbol, windowStart(config), windowEnd(config)
+	src := resolveMarket(symbol)
+	barEnd := endDate
+	if barEnd != "" && src.DayStart != "" {
+		// The last session runs into the next calendar day
+		barEnd += " " + src.DayStart
+	}
+
 	var klines1m, klines5m []Kline
-	if err := DB.Table("klines_1m").Where("symbol = ?", symbol).Where("timestamp >= ?", startDate).Order("timestamp asc").Find(&klines1m).Error; err != nil {
-		return nil, 0, err
+	if err := barQuery(src.Table1m, src.Symbol, startDate, barEnd).Order("timestamp asc").Find(&klines1m).Error; err != nil {
+		return SimData{}, err
 	}
-	if err := DB.Table("klines_5m").Where("symbol = ?", symbol).Where("timestamp >= ?", startDate).Order("timestamp asc").Find(&klines5m).Error; err != nil {
-		return nil, 0, err
+	if err := barQuery(src.Table5m, src.Symbol, startDate, barEnd).Order("timestamp asc").Find(&klines5m).Error; err != nil {
+		return SimData{}, err
 	}
 
-	datesWith1m := make(map[string]bool)
-	for _, k := range klines1m {
-		if len(k.Timestamp) >= 10 {
-			datesWith1m[k.Timestamp[:10]] = true
+	data := SimData{Source: src}
+	if config.PathModel == "refine" {
+		data.Klines, data.SubBars = attachSubBars(klines1m, klines5m)
+	} else {
+		datesWith1m := make(map[string]bool)
+		for _, k := range klines1m {
+			if len(k.Timestamp) >= 10 {
+				datesWith1m[k.Timestamp[:10]] = true
+			}
 		}
-	}
 
-	var klines []Kline
-	klines = append(klines, klines1m...)
-	for _, k := range klines5m {
-		if len(k.Timestamp) >= 10 {
-			date := k.Timestamp[:10]
-			if _, has1m := datesWith1m[date]; !has1m {
-				klines = append(klines, k)
+		var klines []Kline
+		klines = append(klines, klines1m...)
+		for _, k := range klines5m {
+			if len(k.Timestamp) >= 10 {
+				date := k.Timestamp[:10]
+				if _, has1m := datesWith1m[date]; !has1m {
+					klines = append(klines, k)
+				}
 			}
 		}
+
+		sort.Slice(klines, func(i, j int) bool {
+			return klines[i].Timestamp < klines[j].Timestamp
+		})
+		data.Klines = klines
 	}
 
-	sort.Slice(klines, func(i, j int) bool {
-		return klines[i].Timestamp < klines[j].Timestamp
-	})
+	// Ranges and excluded dates: the bars of those days are dropped, the grid carries over
+	traded := data.Klines[:0]
+	for _, k := range data.Klines {
+		if inWindow(config, src.SessionDate(k.Timestamp)) {
+			traded = append(traded, k)
+		}
+	}
+	data.Klines = traded
 
-	if len(klines) == 0 {
-		return nil, 0, nil
+	if len(data.Klines) == 0 {
+		return SimData{}, nil
 	}
 
-	firstPrice := klines[0].Open
-	preClosePrice := firstPrice
+	data.PreClose = data.Klines[0].Open
 	var preCloseKline Kline
-	if err := DB.Table("klines_daily").Where("symbol = ? AND timestamp < ?", symbol, startDate).Order("timestamp desc").First(&preCloseKline).Error; err == nil {
-		preClosePrice = preCloseKline.Close
+	if err := DB.Table(src.TableDaily).Where("symbol = ? AND timestamp < ?", src.Symbol, startDate).Order("timestamp desc").First(&preCloseKline).Error; err == nil {
+		data.PreClose = preCloseKline.Close
+	}
+
+	var daily []Kline
+	if err := barQuery(src.TableDaily, src.Symbol, startDate, endDate).Order("timestamp asc").Find(&daily).Error; err != nil {
+		return SimData{}, err
+	}
+	var symbolRecord Symbol
+	DB.First(&symbolRecord, "symbol = ?", symbol)
+	limitPct := func(date string) float64 {
+		if config.PriceLimitPct > 0 {
+			return config.PriceLimitPct
+		}
+		return priceLimitPct(symbol, symbolRecord.Name, date)
+	}
+	var halts []HaltEvent
+	data.Limits, halts = buildPriceLimits(daily, data.PreClose, limitPct, aShareTick(symbol))
+	if !src.Suspends {
+		// Gold and crypto trade through days without reported volume
+		halts = nil
 	}
 
-	return klines, preClosePrice, nil
+	// Trading days the daily table knows about but the intraday tables do not
+	datesWithBars := make(map[string]bool)
+	for _, k := range data.Klines {
+		datesWithBars[src.SessionDate(k.Timestamp)] = true
+	}
+	lastDate := src.SessionDate(data.Klines[len(data.Klines)-1].Timestamp)
+	for _, d := range daily {
+		date := d.Timestamp[:10]
+		if d.Volume > 0 && date <= lastDate && !datesWithBars[date] {
+			halts = append(halts, HaltEvent{Date: date, Reason: "no_intraday_data"})
+		}
+	}
+	for _, h := range halts {
+		if inWindow(config, h.Date) {
+			data.Halts = append(data.Halts, h)
+		}
+	}
+	sort.Slice(data.Halts, func(i, j int) bool {
+		return data.Halts[i].Date < data.Halts[j].Date
+	})
+
+	actions, err := loadCorporateActions(symbol)
+	if err != nil {
+		return SimData{}, err
+	}
+	for _, a := range actions {
+		if a.ExDate >= src.SessionDate(data.Klines[0].Timestamp) {
+			data.Actions = append(data.Actions, a)
+		}
+	}
+
+	if config.BenchmarkSymbol != "" && config.BenchmarkSymbol != symbol {
+		if data.Benchmark, err = loadBenchmark(config.BenchmarkSymbol, startDate); err != nil {
+			return SimData{}, err
+		}
+	}
+
+	return data, nil
+}
+
+// validateSimConfig rejects option names the strategy or the engine does not know,
+// and a benchmark symbol there is no data for.
+func validateSimConfig(config SimConfig) error {
+	if _, err := newStrategy(config); err != nil {
+		return err
+	}
+	if err := validateLotMatching(config.LotMatching); err != nil {
+		return err
+	}
+	if err := validateSettlement(config.Settlement); err != nil {
+		return err
+	}
+	if err := validateBounds(config); err != nil {
+		return err
+	}
+	if err := validateSizingUnit(config.SizingUnit); err != nil {
+		return err
+	}
+	if err := validateWindow(config); err != nil {
+		return err
+	}
+	if err := validateLiquidity(config); err != nil {
+		return err
+	}
+	if err := validateOrderModel(config); err != nil {
+		return err
+	}
+	if err := validateFeeModel(config.FeeModel); err != nil {
+		return err
+	}
+	return validateBenchmark(config)
 }
 
 func runSimulation(c *gin.Context) {
@@ -141,24 +447,58 @@ func runSimulation(c *gin.Context) {
 	if config.AmountPerGrid <= 0 {
 		config.AmountPerGrid = 100
 	}
+	if err := validateSimConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	klines, preClosePrice, err := getSimulationData(config.Symbol, config.StartDate)
+	data, err := getSimulationData(config)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if len(klines) == 0 {
+	if len(data.Klines) == 0 {
 		c.JSON(http.StatusOK, SimResult{})
 		return
 	}
 
-	result := calcSimulation(klines, config, preClosePrice)
+	result := calcSimulation(data, config)
+	if config.PathReport {
+		// Every model runs on the data its own run loads: "refine" on 5m bars with the 1m
+		// bars inside them attached, the others on the usual (1m where stored) bars
+		usual, refined := data, data
+		if config.PathModel == "refine" {
+			usualConf := config
+			usualConf.PathModel = ""
+			usual, err = getSimulationData(usualConf)
+		} else {
+			refineConf := config
+			refineConf.PathModel = "refine"
+			refined, err = getSimulationData(refineConf)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result.PathSensitivity, result.PathProfitSpread = pathSensitivity(usual, refined, config)
+	}
+
+	// ?save=true&name=...&tags=a,b keeps the run, see RegisterRunRoutes
+	if c.Query("save") == "true" {
+		run, err := saveRun(config, result, c.Query("name"), c.Query("tags"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result.RunID = run.ID
+	}
 	c.JSON(http.StatusOK, result)
 }
 
 type BatchSimConfig struct {
 	Symbol         string  `json:"symbol"`
+	Strategy       string  `json:"strategy"`
 	StartDate      string  `json:"startDate"`
 	BasePrice      float64 `json:"basePrice"`
 	MinStep        float64 `json:"minStep"`
@@ -172,20 +512,92 @@ type BatchSimConfig struct {
 	InitialShares  int64   `json:"initialShares"`
 	InitialCapital float64 `json:"initialCapital"`
 	UsePenetration bool    `json:"usePenetration"`
+	PathModel      string  `json:"pathModel"`
+	LotMatching    string  `json:"lotMatching"`
+	Settlement     string  `json:"settlement"`
+	PriceLimitPct  float64 `json:"priceLimitPct"`
+	IgnoreLimits   bool    `json:"ignoreLimits"`
+	FeeModel       string  `json:"feeModel"`
+	BNBDiscount    bool    `json:"bnbDiscount"`
+
+	AdjustGridOnExDate bool `json:"adjustGridOnExDate"`
+
+	EndDate      string      `json:"endDate"`
+	DateRanges   []DateRange `json:"dateRanges"`
+	ExcludeDates []string    `json:"excludeDates"`
+
+	BuyAmount  float64 `json:"buyAmount"`
+	SellAmount float64 `json:"sellAmount"`
+
+	SizingUnit  string  `json:"sizingUnit"`
+	LotRules    bool    `json:"lotRules"`
+	LotSize     float64 `json:"lotSize"`
+	MinNotional float64 `json:"minNotional"`
+
+	MaxVolumePct float64 `json:"maxVolumePct"`
+
+	OrderType         string `json:"orderType"`
+	OrderDelayBars    int    `json:"orderDelayBars"`
+	OrderDelaySeconds int    `json:"orderDelaySeconds"`
+
+	// Sell step sweep, nested inside the buy step sweep (MinStep..MaxStep); 0 = sell with the buy step
+	SellMinStep      float64 `json:"sellMinStep"`
+	SellMaxStep      float64 `json:"sellMaxStep"`
+	SellStepInterval float64 `json:"sellStepInterval"`
+
+	LadderType     string    `json:"ladderType"`
+	LadderLevels   []float64 `json:"ladderLevels"`
+	LadderMin      float64   `json:"ladderMin"`
+	LadderMax      float64   `json:"ladderMax"`
+	LadderCount    int       `json:"ladderCount"`
+	LadderExponent float64   `json:"ladderExponent"`
+	SnapToTick     bool      `json:"snapToTick"`
+
+	UpperBound    float64 `json:"upperBound"`
+	LowerBound    float64 `json:"lowerBound"`
+	BoundPolicy   string  `json:"boundPolicy"`
+	StopLossPct   float64 `json:"stopLossPct"`
+	TakeProfitPct float64 `json:"takeProfitPct"`
+
+	TrailingMode        string `json:"trailingMode"`
+	TrailingMAPeriod    int    `json:"trailingMAPeriod"`
+	TrailingRangeLevels int    `json:"trailingRangeLevels"`
+	TrailingBars        int    `json:"trailingBars"`
+
+	SizingMode       string      `json:"sizingMode"`
+	SizingMultiplier float64     `json:"sizingMultiplier"`
+	SizingTable      []float64   `json:"sizingTable"`
+	Multipliers      []float64   `json:"multipliers"`  // Optional: sweep these multipliers for every step
+	SizingTables     [][]float64 `json:"sizingTables"` // Optional: sweep these tables for every step
 }
 
 type BatchSimResult struct {
-	Step        float64 `json:"step"`
-	MaxDrawdown float64 `json:"maxDrawdown"`
-	GridProfit  float64 `json:"gridProfit"`
-	FloatProfit float64 `json:"floatProfit"`
-	TotalProfit float64 `json:"totalProfit"`
-	NetPosition float64 `json:"netPosition"`
-	MissedBuys  int     `json:"missedBuys"`
-	MissedSells int     `json:"missedSells"`
-	TotalTx     int     `json:"totalTx"`
-	SharpeRatio float64 `json:"sharpeRatio"`
-	WinRate     float64 `json:"winRate"`
+	Step        float64   `json:"step"`
+	SellStep    float64   `json:"sellStep,omitempty"` // Set when the sell step was swept separately
+	Multiplier  float64   `json:"multiplier,omitempty"`
+	SizingTable []float64 `json:"sizingTable,omitempty"`
+	MaxDrawdown float64   `json:"maxDrawdown"`
+	GridProfit  float64   `json:"gridProfit"`
+	FloatProfit float64   `json:"floatProfit"`
+	TotalProfit float64   `json:"totalProfit"`
+	NetPosition float64   `json:"netPosition"`
+	MissedBuys  int       `json:"missedBuys"`
+	MissedSells int       `json:"missedSells"`
+	TotalTx     int       `json:"totalTx"`
+	SharpeRatio float64   `json:"sharpeRatio"`
+	WinRate     float64   `json:"winRate"`
+
+	SortinoRatio       float64 `json:"sortinoRatio"`
+	CalmarRatio        float64 `json:"calmarRatio"`
+	MaxDrawdownDays    int     `json:"maxDrawdownDays"`
+	UlcerIndex         float64 `json:"ulcerIndex"`
+	TimeInMarket       float64 `json:"timeInMarket"`
+	CapitalUtilization float64 `json:"capitalUtilization"`
+	Turnover           float64 `json:"turnover"`
+	RoundTrips         int     `json:"roundTrips"`
+	AvgHoldingDays     float64 `json:"avgHoldingDays"`
+	PartialFills       int     `json:"partialFills"`
+	FillRatio          float64 `json:"fillRatio"`
 }
 
 func runBatchSimulation(c *gin.Context) {
@@ -202,45 +614,160 @@ func runBatchSimulation(c *gin.Context) {
 	if config.AmountPerGrid <= 0 {
 		config.AmountPerGrid = 100
 	}
-	if config.MinStep <= 0 || config.MaxStep <= 0 || config.StepInterval <= 0 {
+	steps, ok := stepCount(config.MinStep, config.MaxStep, config.StepInterval)
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step parameters"})
 		return
 	}
 
-	klines, preClosePrice, err := getSimulationData(config.Symbol, config.StartDate)
+	sellSteps := []float64{0}
+	sweepSell := config.SellMinStep > 0 || config.SellMaxStep > 0 || config.SellStepInterval > 0
+	sellCount := 1.0
+	if sweepSell {
+		if sellCount, ok = stepCount(config.SellMinStep, config.SellMaxStep, config.SellStepInterval); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sell step parameters"})
+			return
+		}
+	}
+
+	// Sizing schedules to sweep alongside the step; without a sweep list it is just the configured one
+	type sizingSchedule struct {
+		mode       string
+		multiplier float64
+		table      []float64
+	}
+	schedules := []sizingSchedule{{config.SizingMode, config.SizingMultiplier, config.SizingTable}}
+	if len(config.Multipliers) > 0 || len(config.SizingTables) > 0 {
+		schedules = nil
+		for _, m := range config.Multipliers {
+			schedules = append(schedules, sizingSchedule{mode: "multiplier", multiplier: m})
+		}
+		for _, t := range config.SizingTables {
+			schedules = append(schedules, sizingSchedule{mode: "table", table: t})
+		}
+	}
+	// Count the combinations before building them, a wide range is a lot of configs
+	if steps*sellCount*float64(len(schedules)) > maxSweepCombinations {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("more than %d combinations", maxSweepCombinations)})
+		return
+	}
+	if sweepSell {
+		sellSteps = nil
+		for s := config.SellMinStep; s <= config.SellMaxStep+1e-9; s = RoundTo3(s + config.SellStepInterval) {
+			sellSteps = append(sellSteps, RoundTo3(s))
+		}
+	}
+
+	base := SimConfig{
+		Symbol:         config.Symbol,
+		Strategy:       config.Strategy,
+		StartDate:      config.StartDate,
+		BasePrice:      config.BasePrice,
+		GridStepType:   config.GridStepType,
+		CommissionRate: config.CommissionRate,
+		MinCommission:  config.MinCommission,
+		SlippageRate:   config.SlippageRate,
+		AmountPerGrid:  config.AmountPerGrid,
+		InitialShares:  config.InitialShares,
+		InitialCapital: config.InitialCapital,
+		UsePenetration: config.UsePenetration,
+		PathModel:      config.PathModel,
+		LotMatching:    config.LotMatching,
+		Settlement:     config.Settlement,
+		PriceLimitPct:  config.PriceLimitPct,
+		IgnoreLimits:   config.IgnoreLimits,
+		FeeModel:       config.FeeModel,
+		BNBDiscount:    config.BNBDiscount,
+
+		AdjustGridOnExDate: config.AdjustGridOnExDate,
+
+		EndDate:      config.EndDate,
+		DateRanges:   config.DateRanges,
+		ExcludeDates: config.ExcludeDates,
+
+		BuyAmount:  config.BuyAmount,
+		SellAmount: config.SellAmount,
+
+		SizingUnit:  config.SizingUnit,
+		LotRules:    config.LotRules,
+		LotSize:     config.LotSize,
+		MinNotional: config.MinNotional,
+
+		MaxVolumePct: config.MaxVolumePct,
+
+		OrderType:         config.OrderType,
+		OrderDelayBars:    config.OrderDelayBars,
+		OrderDelaySeconds: config.OrderDelaySeconds,
+
+		LadderType:     config.LadderType,
+		LadderLevels:   config.LadderLevels,
+		LadderMin:      config.LadderMin,
+		LadderMax:      config.LadderMax,
+		LadderCount:    config.LadderCount,
+		LadderExponent: config.LadderExponent,
+		SnapToTick:     config.SnapToTick,
+
+		UpperBound:    config.UpperBound,
+		LowerBound:    config.LowerBound,
+		BoundPolicy:   config.BoundPolicy,
+		StopLossPct:   config.StopLossPct,
+		TakeProfitPct: config.TakeProfitPct,
+
+		TrailingMode:        config.TrailingMode,
+		TrailingMAPeriod:    config.TrailingMAPeriod,
+		TrailingRangeLevels: config.TrailingRangeLevels,
+		TrailingBars:        config.TrailingBars,
+	}
+	for _, sch := range schedules {
+		probe := base
+		probe.GridStep = config.MinStep
+		probe.SellStep = sellSteps[0]
+		probe.SizingMode = sch.mode
+		probe.SizingMultiplier = sch.multiplier
+		probe.SizingTable = sch.table
+		if err := validateSimConfig(probe); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	data, err := getSimulationData(base)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if len(klines) == 0 {
+	if len(data.Klines) == 0 {
 		c.JSON(http.StatusOK, []BatchSimResult{})
 		return
 	}
 
-	var results []BatchSimResult
-
-	for step := config.MinStep; step <= config.MaxStep; step += config.StepInterval {
-		step = RoundTo3(step) // avoid float precision drift
-		simConf := SimConfig{
-			Symbol:         config.Symbol,
-			StartDate:      config.StartDate,
-			BasePrice:      config.BasePrice,
-			GridStep:       step,
-			GridStepType:   config.GridStepType,
-			CommissionRate: config.CommissionRate,
-			MinCommission:  config.MinCommission,
-			SlippageRate:   config.SlippageRate,
-			AmountPerGrid:  config.AmountPerGrid,
-			InitialShares:  config.InitialShares,
-			InitialCapital: config.InitialCapital,
-			UsePenetration: config.UsePenetration,
+	var configs []SimConfig
+	// Round in the loop header to avoid float precision drift (0.1 + 0.2 > 0.3)
+	for step := config.MinStep; step <= config.MaxStep+1e-9; step = RoundTo3(step + config.StepInterval) {
+		for _, sellStep := range sellSteps {
+			for _, sch := range schedules {
+				simConf := base
+				simConf.GridStep = RoundTo3(step)
+				simConf.SellStep = sellStep
+				simConf.SizingMode = sch.mode
+				simConf.SizingMultiplier = sch.multiplier
+				simConf.SizingTable = sch.table
+				configs = append(configs, simConf)
+			}
 		}
+	}
 
-		res := calcSimulation(klines, simConf, preClosePrice)
+	results := make([]BatchSimResult, len(configs))
+	runParallel(len(configs), func(i int) {
+		simConf := configs[i]
+		res := calcSimulation(data, simConf)
 
-		results = append(results, BatchSimResult{
-			Step:        step,
+		results[i] = BatchSimResult{
+			Step:        simConf.GridStep,
+			SellStep:    simConf.SellStep,
+			Multiplier:  simConf.SizingMultiplier,
+			SizingTable: simConf.SizingTable,
 			MaxDrawdown: res.MaxDrawdown,
 			GridProfit:  res.TotalProfit,
 			FloatProfit: res.TotalFloating,
@@ -251,192 +778,491 @@ func runBatchSimulation(c *gin.Context) {
 			TotalTx:     res.TotalTx,
 			SharpeRatio: res.SharpeRatio,
 			WinRate:     res.WinRate,
-		})
-	}
+
+			SortinoRatio:       res.SortinoRatio,
+			CalmarRatio:        res.CalmarRatio,
+			MaxDrawdownDays:    res.MaxDrawdownDays,
+			UlcerIndex:         res.UlcerIndex,
+			TimeInMarket:       res.TimeInMarket,
+			CapitalUtilization: res.CapitalUtilization,
+			Turnover:           res.Turnover,
+			RoundTrips:         res.RoundTrips,
+			AvgHoldingDays:     res.AvgHoldingDays,
+			PartialFills:       res.PartialFills,
+			FillRatio:          res.FillRatio,
+		}
+	})
 
 	c.JSON(http.StatusOK, gin.H{"data": results})
 }
 
-func calcSimulation(klines []Kline, config SimConfig, preClosePrice float64) SimResult {
-	if len(klines) == 0 {
-		return SimResult{}
+// simEngine owns the account state of one simulation run. The strategy decides
+// when to trade; the engine fills its orders and keeps the books for SimResult.
+type simEngine struct {
+	config   SimConfig
+	strategy Strategy
+
+	pf             Portfolio
+	fees           FeeModel
+	lots           lotBook
+	limits         map[string]PriceLimit
+	limit          PriceLimit // Band of the current bar's day, zero when unknown
+	halted         map[string]bool
+	market         MarketSource // Session rules: which trading day a bar belongs to
+	lotRule        LotRule
+	minCash        float64
+	bar            Kline
+	stat           *DailyStat
+	dailyStatsMap  map[string]*DailyStat
+	gridDensityMap map[float64]int
+	result         SimResult
+
+	actions []CorporateAction // Pending, sorted by ex-date
+
+	// Buy-and-hold of one share from the previous close, for the total-return benchmark
+	benchShares float64
+	benchCash   float64
+	benchmark   []BenchmarkPoint // Another symbol to compare against instead
+
+	// Portfolio runs: the cash pool shared with the other legs
+	pool             *cashPool
+	contentionMisses int // Buys missed because other legs had used the pool
+
+	// Round trips: grid lots (or parts of them) closed by a sell
+	roundTrips    int
+	roundTripWins int // Closed at a profit net of both sides' fees
+	holdingDays   float64
+
+	// Liquidity: what is left of the bar's volume and the orders waiting for more
+	volumeLeft    float64
+	resting       []restingOrder // Oldest first
+	orderedShares float64
+	filledShares  float64
+
+	// Order model: the grid's orders are placed when a grid order fills
+	barIndex   int
+	placedBar  int    // Bar of the last grid fill (-1 = none yet)
+	placedTime string // Its timestamp
+	openFill   bool   // A grid order of the current bar filled at a gapped open
+
+	// Orders the grid retries on every bar of a session are counted on the first one
+	blocked map[string]bool // Keys already counted in the current session
+
+	preClose   float64
+	stopped    bool // An exit fired: the strategy no longer trades, remaining shares are sold
+	outOfRange bool // "reenter": the price has left the bounds and not come back yet
+}
+
+func newSimEngine(config SimConfig, strategy Strategy, preClosePrice float64) *simEngine {
+	e := &simEngine{
+		config:         config,
+		strategy:       strategy,
+		fees:           newFeeModel(config),
+		lotRule:        lotRuleFor(config),
+		dailyStatsMap:  make(map[string]*DailyStat),
+		gridDensityMap: make(map[float64]int),
+		benchShares:    1,
+		placedBar:      -1,
+		barIndex:       -1,
+		preClose:       preClosePrice,
+	}
+	e.pf = Portfolio{
+		Cash:          config.InitialCapital, // 0 means infinity
+		InitialShares: float64(config.InitialShares),
+	}
+	e.lots.rule = config.LotMatching
+	e.lots.t1 = settlementRule(config.Symbol, config.Settlement) == "T+1"
+	if config.InitialShares > 0 {
+		// The base position is carried at the previous close, the same reference the equity curve uses
+		e.lots.open(Lot{Price: preClosePrice, Amount: float64(config.InitialShares), Initial: true})
 	}
 
-	firstPrice := klines[0].Open
+	initialPosValueAtStart := float64(config.InitialShares) * preClosePrice
+	e.minCash = -initialPosValueAtStart
+	if config.InitialCapital > 0 {
+		e.minCash = e.pf.Cash - initialPosValueAtStart // Use provided capital instead
+	}
+	return e
+}
+
+func (e *simEngine) step(bar Bar) {
+	k := bar.Kline
+	date := e.market.SessionDate(k.Timestamp)
+	if _, ok := e.dailyStatsMap[date]; !ok {
+		e.dailyStatsMap[date] = &DailyStat{Date: date}
+		e.stat = e.dailyStatsMap[date]
+		e.blocked = make(map[string]bool)
+		e.expireResting()
+		// An ex-date on a non-trading day takes effect at the next session
+		for len(e.actions) > 0 && e.actions[0].ExDate <= date {
+			e.applyCorporateAction(e.actions[0])
+			e.actions = e.actions[1:]
+		}
+		e.stat.Value = e.pf.InitialShares * k.Close
+	}
+	e.stat = e.dailyStatsMap[date]
+	e.stat.initialShares = e.pf.InitialShares
+	e.stat.ClosePrice = RoundTo3(k.Close)
+	e.bar = k
+	e.barIndex++
+	e.openFill = false
+	e.limit = e.limits[date]
+	e.volumeLeft = float64(k.Volume) * e.market.VolumeUnit * e.config.MaxVolumePct / 100
+
+	if e.stopped {
+		e.liquidate(k.Open)
+	} else {
+		e.workResting()
+		e.strategy.OnBar(bar, &e.pf, e)
+		e.checkExit(k)
+	}
+
+	marketValue := e.pf.Position * k.Close
+	e.stat.NetValue = e.pf.Cash + marketValue
+	e.stat.holdings = e.pf.Holdings()
+	e.stat.benchGrowth = 1
+	if e.preClose > 0 {
+		e.stat.benchGrowth = (e.benchShares*k.Close + e.benchCash) / e.preClose
+	}
+}
+
+// Submit implements Broker.
+func (e *simEngine) Submit(o Order) (OrderStatus, float64) {
+	if !e.inBounds(o) {
+		return OrderOutOfRange, 0
+	}
+	if !e.orderActive() {
+		e.result.InactiveOrders++
+		return OrderInactive, 0
+	}
+	return e.fill(o)
+}
+
+// fill rounds an order to the lot rules and executes it, returning the shares filled.
+func (e *simEngine) fill(o Order) (OrderStatus, float64) {
+	o, ok := e.applyLotRules(o)
+	if !ok {
+		return OrderBelowMinimum, 0
+	}
+	if allowed := e.liquidityCap(o.Amount); allowed < o.Amount-1e-9 {
+		return e.fillPartial(o, allowed)
+	}
+	status := e.execute(o)
+	if status != OrderFilled {
+		return status, 0
+	}
+	e.placeOrders(o)
+	e.volumeLeft -= o.Amount
+	e.orderedShares += o.Amount
+	e.filledShares += o.Amount
+	return status, o.Amount
+}
+
+// execute books an order that has passed the lot and volume rules.
+func (e *simEngine) execute(o Order) OrderStatus {
+	if o.Side == "BUY" {
+		return e.buy(o)
+	}
+	return e.sell(o)
+}
+
+// applyCorporateAction pays the dividend on everything held before the ex-date and
+// splits the position. The cash is credited on the ex-date rather than the pay date.
+func (e *simEngine) applyCorporateAction(a CorporateAction) {
+	shares := e.pf.Holdings()
+	cash := shares * a.CashPerShare
+	e.addCash(cash)
+	e.stat.Dividend += cash
+
+	e.benchCash += e.benchShares * a.CashPerShare
+	e.benchShares *= a.ratio()
+
+	ratio := a.ratio()
+	e.pf.Position *= ratio
+	e.pf.InitialShares *= ratio
+	e.lots.adjust(a, e.config.AdjustGridOnExDate)
+	if s, ok := e.strategy.(corporateActionAware); ok {
+		s.OnCorporateAction(a, e.config.AdjustGridOnExDate)
+	}
+
+	e.result.CorporateActions = append(e.result.CorporateActions, CorporateActionEvent{
+		Date:         e.stat.Date,
+		CashPerShare: a.CashPerShare,
+		SplitRatio:   a.ratio(),
+		Shares:       shares,
+		Cash:         RoundTo3(cash),
+	})
+}
+
+// addCash books a cash flow on the account and, in a portfolio run, on the shared pool.
+func (e *simEngine) addCash(amount float64) {
+	e.pf.Cash += amount
+	if e.pool != nil {
+		e.pool.add(amount)
+	}
+}
+
+// limitLocked reports whether the current bar traded entirely at the limit that
+// takes away the other side of an order: no sellers at limit-up, no buyers at limit-down.
+func (e *simEngine) limitLocked(side string) bool {
+	if e.limit.Up == 0 {
+		return false
+	}
+	if side == "BUY" {
+		return e.bar.Low >= e.limit.Up-0.00001
+	}
+	return e.bar.High <= e.limit.Down+0.00001
+}
 
-	var initialIndex int
-	var stepValue float64
+// firstBlock reports whether the order blocked under key has not been counted yet this
+// session, and marks it counted. A blocked level keeps its place in the grid, so the
+// strategy sends it again on every bar that reaches it.
+func (e *simEngine) firstBlock(key string) bool {
+	if e.blocked[key] {
+		return false
+	}
+	e.blocked[key] = true
+	return true
+}
 
-	if config.GridStepType == "absolute" {
-		stepValue = config.GridStep
-		initialIndex = int((firstPrice - config.BasePrice) / stepValue)
+// blockAtLimit records an order the locked limit kept from filling, once per side in
+// a session.
+func (e *simEngine) blockAtLimit(o Order) {
+	if !e.firstBlock("limit " + o.Side) {
+		return
+	}
+	reason := "limit_up"
+	if o.Side == "BUY" {
+		e.result.LimitBlockedBuys++
 	} else {
-		stepValue = config.GridStep / 100.0
-		initialIndex = int((firstPrice/config.BasePrice - 1) / stepValue)
+		e.result.LimitBlockedSells++
+		reason = "limit_down"
 	}
+	e.result.BlockedEvents = append(e.result.BlockedEvents, BlockedEvent{
+		Time:   e.bar.Timestamp,
+		Side:   o.Side,
+		Price:  RoundTo3(o.Price),
+		Reason: reason,
+	})
+}
 
-	lastExecIndex := initialIndex
-	currentCash := config.InitialCapital // 0 means infinity
-	currentPos := 0.0
-	dailyStatsMap := make(map[string]*DailyStat)
-	gridDensityMap := make(map[float64]int)
-	var result SimResult
+func (e *simEngine) buy(o Order) OrderStatus {
+	if e.limitLocked(o.Side) {
+		e.blockAtLimit(o)
+		return OrderLimitLocked
+	}
 
-	initialPosValueAtStart := float64(config.InitialShares) * preClosePrice
-	minCash := -initialPosValueAtStart
+	// Apply Slippage: buy higher
+	actualBuyPrice, gapped := e.executionPrice(o)
+	cost := actualBuyPrice * o.Amount
+	fees := e.fees.Fees("BUY", e.stat.Date, actualBuyPrice, o.Amount)
+	comm := fees.Total()
+
+	// Check if we hit capital limit
+	if e.pool != nil && e.pool.cash < (cost+comm) {
+		// The shared pool is short; if this leg alone could still afford it, another leg took the cash
+		e.result.MissedBuys++
+		if e.pf.Cash >= (cost + comm) {
+			e.contentionMisses++
+		}
+		return OrderNoCash
+	}
+	if e.pool == nil && e.config.InitialCapital > 0 && e.pf.Cash < (cost+comm) {
+		e.result.MissedBuys++
+		return OrderNoCash
+	}
+	if cost <= 0 {
+		return OrderInvalid
+	}
 
-	if config.InitialCapital > 0 {
-		minCash = currentCash - initialPosValueAtStart // Use provided capital instead
+	e.stat.BuyCount++
+	e.stat.Commission += comm
+	e.stat.Fees.Add(fees)
+
+	e.addCash(-(cost + comm))
+	e.pf.Position += o.Amount
+	if e.pf.Cash < e.minCash {
+		e.minCash = e.pf.Cash
 	}
+	e.lots.open(Lot{
+		OpenTime: e.bar.Timestamp,
+		session:  e.stat.Date,
+		Level:    RoundTo3(o.Price),
+		Price:    actualBuyPrice,
+		Amount:   o.Amount,
+		fee:      comm / o.Amount,
+	})
 
-	for _, k := range klines {
-		date := k.Timestamp[:10]
-		if _, ok := dailyStatsMap[date]; !ok {
-			dailyStatsMap[date] = &DailyStat{
-				Date:  date,
-				Value: float64(config.InitialShares) * k.Close,
-			}
+	e.result.Trades = append(e.result.Trades, Trade{
+		Time:   e.bar.Timestamp,
+		Type:   "BUY",
+		Price:  RoundTo3(actualBuyPrice),
+		Amount: o.Amount,
+		Comm:   RoundTo3(comm),
+		Fees:   fees.Rounded(),
+		Reason: o.reason(),
+	})
+	if o.Reason == "" {
+		e.gridDensityMap[RoundTo3(o.Price)]++
+	}
+	if gapped {
+		e.filledAtOpen()
+	}
+	return OrderFilled
+}
+
+func (e *simEngine) sell(o Order) OrderStatus {
+	if e.limitLocked(o.Side) {
+		e.blockAtLimit(o)
+		return OrderLimitLocked
+	}
+
+	// Check if we have inventory to sell
+	if e.pf.Holdings() < o.Amount-0.0001 {
+		e.result.MissedSells++
+		return OrderNoInventory
+	}
+	if e.lots.sellable(e.stat.Date) < o.Amount-0.0001 {
+		if e.firstBlock(fmt.Sprintf("unsettled %.3f", o.Price)) {
+			e.result.SettlementBlockedSells++
 		}
-		stat := dailyStatsMap[date]
-		stat.ClosePrice = RoundTo3(k.Close)
+		return OrderUnsettled
+	}
 
-		passes := []string{"B", "S"}
-		if k.Open > k.Close {
-			passes = []string{"S", "B"}
+	// Apply Slippage: sell lower
+	actualSellPrice, gapped := e.executionPrice(o)
+	revenue := actualSellPrice * o.Amount
+	fees := e.fees.Fees("SELL", e.stat.Date, actualSellPrice, o.Amount)
+	comm := fees.Total()
+
+	e.stat.SellCount++
+	e.stat.Commission += comm
+	e.stat.Fees.Add(fees)
+
+	// Gross profit is measured against the lots this sell actually closes
+	gross := 0.0
+	for _, f := range e.lots.consume(o.Amount, RoundTo3(o.MatchLevel), e.stat.Date) {
+		gross += (actualSellPrice - f.Lot.Price) * f.Amount
+		if !f.Lot.Initial {
+			e.closeRoundTrip(f, actualSellPrice, comm*f.Amount/o.Amount)
 		}
+	}
+	e.stat.GrossProfit += gross
+
+	e.addCash(revenue - comm)
+	e.pf.Position -= o.Amount
+
+	e.result.Trades = append(e.result.Trades, Trade{
+		Time:   e.bar.Timestamp,
+		Type:   "SELL",
+		Price:  RoundTo3(actualSellPrice),
+		Amount: o.Amount,
+		Comm:   RoundTo3(comm),
+		Fees:   fees.Rounded(),
+		Reason: o.reason(),
+	})
+	if o.Reason == "" {
+		e.gridDensityMap[RoundTo3(o.Price)]++
+	}
+	if gapped {
+		e.filledAtOpen()
+	}
+	return OrderFilled
+}
 
-		for _, pType := range passes {
-			if pType == "B" {
-				for {
-					nextBuyIndex := lastExecIndex - 1
-					var nextBuyPrice float64
-
-					if config.GridStepType == "absolute" {
-						nextBuyPrice = RoundTo3(config.BasePrice + float64(nextBuyIndex)*stepValue)
-					} else {
-						nextBuyPrice = RoundTo3(config.BasePrice * (1 + float64(nextBuyIndex)*stepValue))
-					}
-
-					triggered := false
-					if config.UsePenetration {
-						triggered = RoundTo3(k.Low) < nextBuyPrice-0.00001
-					} else {
-						triggered = RoundTo3(k.Low) <= nextBuyPrice+0.00001
-					}
-
-					if triggered {
-						// Apply Slippage: buy higher
-						actualBuyPrice := nextBuyPrice * (1 + config.SlippageRate)
-						cost := actualBuyPrice * config.AmountPerGrid
-						comm := math.Max(cost*config.CommissionRate, config.MinCommission)
-
-						// Check if we hit capital limit
-						if config.InitialCapital > 0 && currentCash < (cost+comm) {
-							result.MissedBuys++
-							break
-						}
-
-						if cost > 0 {
-							stat.BuyCount++
-							stat.Commission += comm
-							lastExecIndex = nextBuyIndex
-
-							currentCash -= (cost + comm)
-							currentPos += config.AmountPerGrid
-							if currentCash < minCash {
-								minCash = currentCash
-							}
-
-							result.Trades = append(result.Trades, Trade{
-								Time:   k.Timestamp,
-								Type:   "BUY",
-								Price:  RoundTo3(actualBuyPrice),
-								Amount: config.AmountPerGrid,
-								Comm:   RoundTo3(comm),
-							})
-
-							priceKey := RoundTo3(nextBuyPrice)
-							gridDensityMap[priceKey]++
-						} else {
-							break
-						}
-					} else {
-						break
-					}
-				}
-			} else {
-				for {
-					nextSellIndex := lastExecIndex + 1
-					var nextSellPrice float64
-					var buyPrice float64
-
-					if config.GridStepType == "absolute" {
-						nextSellPrice = RoundTo3(config.BasePrice + float64(nextSellIndex)*stepValue)
-						buyPrice = RoundTo3(config.BasePrice + float64(nextSellIndex-1)*stepValue)
-					} else {
-						nextSellPrice = RoundTo3(config.BasePrice * (1 + float64(nextSellIndex)*stepValue))
-						buyPrice = RoundTo3(config.BasePrice * (1 + float64(nextSellIndex-1)*stepValue))
-					}
-
-					triggered := false
-					if config.UsePenetration {
-						triggered = RoundTo3(k.High) > nextSellPrice+0.00001
-					} else {
-						triggered = RoundTo3(k.High) >= nextSellPrice-0.00001
-					}
-
-					if triggered {
-						// Check if we have inventory to sell
-						if float64(config.InitialShares)+currentPos < config.AmountPerGrid-0.0001 {
-							// No inventory, just move the grid up
-							result.MissedSells++
-							lastExecIndex = nextSellIndex
-							continue
-						}
-
-						// Apply Slippage: sell lower
-						actualSellPrice := nextSellPrice * (1 - config.SlippageRate)
-						revenue := actualSellPrice * config.AmountPerGrid
-						comm := math.Max(revenue*config.CommissionRate, config.MinCommission)
-
-						stat.SellCount++
-						stat.Commission += comm
-
-						// True cost basis needs to track average cost ideally, but we rely on simple match.
-						// Using target buy price for PnL calculation is slightly inaccurate if buying slippage isn't matched.
-						// To be fair, let's calculate gross using actualSellPrice - (buyPrice * (1+Slippage))
-						actualBuyPriceForThisSell := buyPrice * (1 + config.SlippageRate)
-						gross := (actualSellPrice - actualBuyPriceForThisSell) * config.AmountPerGrid
-						stat.GrossProfit += gross
-
-						lastExecIndex = nextSellIndex
-
-						currentCash += (revenue - comm)
-						currentPos -= config.AmountPerGrid
-
-						result.Trades = append(result.Trades, Trade{
-							Time:   k.Timestamp,
-							Type:   "SELL",
-							Price:  RoundTo3(actualSellPrice),
-							Amount: config.AmountPerGrid,
-							Comm:   RoundTo3(comm),
-						})
-
-						priceKey := RoundTo3(nextSellPrice)
-						gridDensityMap[priceKey]++
-					} else {
-						break
-					}
-				}
-			}
+func calcSimulation(data SimData, config SimConfig) SimResult {
+	if len(data.Klines) == 0 {
+		return SimResult{}
+	}
+
+	e, err := newRun(data, config)
+	if err != nil {
+		return SimResult{}
+	}
+	for _, k := range data.Klines {
+		e.feed(k, data)
+	}
+	result := e.finish(data.Klines, data.PreClose)
+	result.Halts = data.Halts
+	return result
+}
+
+// newRun prepares an engine for data: the strategy, the corporate actions and, unless
+// IgnoreLimits is set, the price limits and halted days.
+func newRun(data SimData, config SimConfig) (*simEngine, error) {
+	strategy, err := newStrategy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	e := newSimEngine(config, strategy, data.PreClose)
+	e.actions = data.Actions
+	e.benchmark = data.Benchmark
+	e.market = data.Source
+	e.halted = make(map[string]bool)
+	if !config.IgnoreLimits {
+		e.limits = data.Limits
+		for _, h := range data.Halts {
+			e.halted[h.Date] = true
 		}
+	}
+	return e, nil
+}
+
+// feed steps the engine through k, one of data's bars.
+func (e *simEngine) feed(k Kline, data SimData) {
+	// Bars stored for a suspended day are not tradable
+	if e.halted[e.market.SessionDate(k.Timestamp)] {
+		return
+	}
+	e.step(Bar{Kline: k, Path: barPath(e.config.PathModel, k, data.SubBars[k.Timestamp])})
+}
 
-		marketValue := currentPos * k.Close
-		stat.NetValue = currentCash + marketValue
+// pathSensitivity reruns config under every path model and reports how far the
+// total profit moves with the intra-bar ordering assumption. Each model runs on the
+// data a run of it loads: "refine" on refined, the others on data. "refine" is left out
+// when no 1m bars are stored for the window: without them it is identical to "nearest".
+func pathSensitivity(data, refined SimData, config SimConfig) ([]PathScenario, float64) {
+	var scenarios []PathScenario
+	minProfit, maxProfit := math.Inf(1), math.Inf(-1)
+	for _, model := range pathModels {
+		conf := config
+		conf.PathModel = model
+		var res SimResult
+		if model == "refine" {
+			if len(refined.SubBars) == 0 {
+				continue
+			}
+			res = calcSimulation(refined, conf)
+		} else {
+			res = calcSimulation(data, conf)
+		}
+		scenarios = append(scenarios, PathScenario{
+			PathModel:   model,
+			TotalProfit: res.TotalYieldAmount,
+			GridProfit:  res.TotalProfit,
+			TotalTx:     res.TotalTx,
+		})
+		minProfit = math.Min(minProfit, res.TotalYieldAmount)
+		maxProfit = math.Max(maxProfit, res.TotalYieldAmount)
+	}
+	if len(scenarios) == 0 {
+		return nil, 0
 	}
+	return scenarios, RoundTo3(maxProfit - minProfit)
+}
+
+// finish turns the engine's books into the final SimResult and its metrics.
+func (e *simEngine) finish(klines []Kline, preClosePrice float64) SimResult {
+	config := e.config
+	result := e.result
+	dailyStatsMap := e.dailyStatsMap
+	gridDensityMap := e.gridDensityMap
+	minCash := e.minCash
+	currentPos := e.pf.Position
+	firstPrice := klines[0].Open
 
 	var sortedStats []DailyStat
-	var totalBuyCount, totalSellCount int
 	var dailyNetValues []float64
 
 	initialCapital := config.InitialCapital
@@ -444,20 +1270,25 @@ func calcSimulation(klines []Kline, config SimConfig, preClosePrice float64) Sim
 		initialCapital = math.Abs(minCash)
 		if initialCapital == 0 {
 			initialCapital = firstPrice * config.AmountPerGrid
+			if config.SizingUnit == "value" {
+				initialCapital = config.AmountPerGrid
+			}
 		}
 	}
 
 	for _, s := range dailyStatsMap {
+		result.Dividends += s.Dividend
+		s.Dividend = RoundTo3(s.Dividend)
 		s.RealizedProfit = RoundTo3(s.GrossProfit - s.Commission)
 		s.GrossProfit = RoundTo3(s.GrossProfit)
 		s.Commission = RoundTo3(s.Commission)
+		result.Fees.Add(s.Fees)
+		s.Fees = s.Fees.Rounded()
 		sortedStats = append(sortedStats, *s)
 
 		result.TotalProfit += s.RealizedProfit
 		result.TotalTx += (s.BuyCount + s.SellCount)
 		result.TotalComm += s.Commission
-		totalBuyCount += s.BuyCount
-		totalSellCount += s.SellCount
 	}
 
 	sort.Slice(sortedStats, func(i, j int) bool {
@@ -472,10 +1303,12 @@ func calcSimulation(klines []Kline, config SimConfig, preClosePrice float64) Sim
 	for i := range sortedStats {
 		s := &sortedStats[i]
 
-		InitialPosPnL := float64(config.InitialShares) * (s.ClosePrice - preClosePrice)
-		equity := initialCapital + s.NetValue + InitialPosPnL
+		InitialPosPnL := s.initialShares*s.ClosePrice - float64(config.InitialShares)*preClosePrice
+		// NetValue already contains the provided capital; without one it is a pure cash flow
+		equity := initialCapital - config.InitialCapital + s.NetValue + InitialPosPnL
 
 		s.NetProfit = RoundTo3(equity - lastEquity)
+		s.Equity = RoundTo3(equity)
 		lastEquity = equity
 
 		if equity > maxEquity {
@@ -494,9 +1327,21 @@ func calcSimulation(klines []Kline, config SimConfig, preClosePrice float64) Sim
 		dailyNetValues = append(dailyNetValues, equity)
 	}
 
-	result.NetPosition = float64(int64(totalBuyCount-totalSellCount)*int64(config.AmountPerGrid) + config.InitialShares)
 	result.TotalProfit = RoundTo3(result.TotalProfit)
+	result.Capital = RoundTo3(initialCapital)
+	result.LotRule = e.lotRule
+	result.RoundingRemainder = RoundTo3(result.RoundingRemainder)
+	for _, r := range e.resting {
+		result.ExpiredShares += r.Amount
+	}
+	result.ExpiredShares = RoundTo3(result.ExpiredShares)
+	if e.orderedShares > 0 {
+		result.FillRatio = RoundTo3(e.filledShares / e.orderedShares * 100)
+	}
+	result.Dividends = RoundTo3(result.Dividends)
 	result.TotalComm = RoundTo3(result.TotalComm)
+	result.Fees = result.Fees.Rounded()
+	result.FeeModel = feeModelName(config)
 	result.DailyStats = sortedStats
 	result.ChartData = klines
 
@@ -512,14 +1357,12 @@ func calcSimulation(klines []Kline, config SimConfig, preClosePrice float64) Sim
 	})
 	result.GridDensityData = gridDensityData
 
-	result.MaxDrawdown = RoundTo3(math.Abs(maxDrawdown) * 100)
-
-	if totalBuyCount > 0 {
-		result.WinRate = RoundTo3(float64(totalSellCount) / float64(totalBuyCount) * 100)
-	} else {
-		result.WinRate = 0
+	if r, ok := e.strategy.(recenterer); ok {
+		result.RecenterEvents = r.RecenterEvents()
 	}
 
+	result.MaxDrawdown = RoundTo3(math.Abs(maxDrawdown) * 100)
+
 	var dailyReturns []float64
 	for i := 1; i < len(dailyNetValues); i++ {
 		r := (dailyNetValues[i] - dailyNetValues[i-1]) / dailyNetValues[i-1]
@@ -556,15 +1399,38 @@ func calcSimulation(klines []Kline, config SimConfig, preClosePrice float64) Sim
 		finalEquity := dailyNetValues[len(dailyNetValues)-1]
 		result.PeriodReturn = RoundTo3((finalEquity/initialCapital - 1) * 100)
 		result.TotalYieldAmount = RoundTo3(finalEquity - initialCapital)
-		result.TotalFloating = RoundTo3(result.TotalYieldAmount - result.TotalProfit)
+		result.TotalFloating = RoundTo3(result.TotalYieldAmount - result.TotalProfit - result.Dividends)
+	}
+	e.extendedMetrics(&result, sortedStats, dailyNetValues, initialCapital)
+	e.benchmarkCurve(sortedStats, initialCapital)
+	benchmarkMetrics(&result, sortedStats, initialCapital)
+	result.BenchmarkSymbol = config.Symbol
+	if len(e.benchmark) > 0 {
+		result.BenchmarkSymbol = config.BenchmarkSymbol
+	}
+
+	src := resolveMarket(config.Symbol)
+	result.Market = string(src.Market)
+	result.Timezone = src.Timezone
+	result.Settlement = settlementRule(config.Symbol, config.Settlement)
+	result.LotMatching = e.lots.rule
+	if result.LotMatching == "" {
+		result.LotMatching = "grid"
+	}
+	result.OpenLots = e.lots.snapshot(klines[len(klines)-1].Close)
+	for _, l := range result.OpenLots {
+		result.CostBasis += l.CostBasis
+		result.UnrealizedPnL += l.UnrealizedPnL
 	}
+	result.CostBasis = RoundTo3(result.CostBasis)
+	result.UnrealizedPnL = RoundTo3(result.UnrealizedPnL)
 
 	if preClosePrice > 0 {
 		lastPrice := klines[len(klines)-1].Close
-		result.BenchmarkReturn = RoundTo3((lastPrice - preClosePrice) / preClosePrice * 100)
+		result.BenchmarkReturn = RoundTo3((e.benchShares*lastPrice + e.benchCash - preClosePrice) / preClosePrice * 100)
 	}
 
-	result.NetPosition = float64(config.InitialShares) + currentPos
+	result.NetPosition = e.pf.InitialShares + currentPos
 
 	return result
 }
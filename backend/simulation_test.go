@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Run with -update to rewrite the golden files after an intended change of results,
+// then review the diff of testdata/golden before committing it.
+var update = flag.Bool("update", false, "rewrite testdata/golden from the current results")
+
+// goldenCases run against the fixture bars in testdata: 512890 (an A-share ETF, 5m bars
+// from 2024-01-02 to 2024-01-10, 1m bars on 2024-01-04, a dividend on 2024-01-08) and
+// 00700 (HK, 5m bars from 2024-01-02 to 2024-01-04). Each result is compared with
+// testdata/golden/<name>.json.
+var goldenCases = []struct {
+	name   string
+	config SimConfig
+}{
+	{"basic", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 1000}},
+	{"penetration", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 1000, UsePenetration: true}},
+	{"capital", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 1000,
+		InitialCapital: 20000, InitialShares: 5000, CommissionRate: 0.0002, MinCommission: 0.2, SlippageRate: 0.0005}},
+	{"absolute_ohlc", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.005, GridStepType: "absolute",
+		AmountPerGrid: 1000, PathModel: "ohlc", LotMatching: "fifo"}},
+	{"refine", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.3, AmountPerGrid: 1000, PathModel: "refine"}},
+	{"t0_lifo", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 1000,
+		Settlement: "T+0", LotMatching: "lifo"}},
+	{"stop_loss", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 1000,
+		InitialCapital: 20000, LowerBound: 1.05, BoundPolicy: "stop_loss"}},
+	{"trailing_shift", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 1000,
+		TrailingMode: "shift", TrailingRangeLevels: 3, SizingMode: "multiplier", SizingMultiplier: 1.5, LotRules: true}},
+	{"window", SimConfig{Symbol: "512890", StartDate: "2024-01-03", EndDate: "2024-01-09", ExcludeDates: []string{"2024-01-05"},
+		BasePrice: 1.09, GridStep: 0.5, AmountPerGrid: 1000, InitialShares: 2000, AdjustGridOnExDate: true}},
+	{"order_model", SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 0.5, AmountPerGrid: 20000,
+		LotRules: true, MaxVolumePct: 1, OrderType: "limit", OrderDelayBars: 2}},
+	{"hk_value", SimConfig{Symbol: "00700", StartDate: "2024-01-02", BasePrice: 300, GridStep: 0.5, AmountPerGrid: 30000,
+		SizingUnit: "value", InitialCapital: 300000, InitialShares: 1000}},
+}
+
+func TestSimulationGolden(t *testing.T) {
+	openTestDB(t)
+
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateSimConfig(tc.config); err != nil {
+				t.Fatal(err)
+			}
+			data, err := getSimulationData(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data.Klines) == 0 {
+				t.Fatal("no bars for the case in testdata")
+			}
+			res := calcSimulation(data, tc.config)
+			res.ChartData = nil // The input bars, already in testdata
+			got, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "golden", tc.name+".json")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if line, w, g := firstDiff(string(want), string(got)); line > 0 {
+				t.Errorf("result differs from %s at line %d:\n  want: %s\n  got:  %s", path, line, w, g)
+			}
+		})
+	}
+}
+
+// TestEquityCountsCapitalOnce runs a grid too wide to trade: with an InitialCapital the
+// equity curve must stay at the capital. DailyStat.NetValue already holds the capital,
+// so adding it again used to report a +100% return for every capped run.
+func TestEquityCountsCapitalOnce(t *testing.T) {
+	openTestDB(t)
+	config := SimConfig{Symbol: "512890", StartDate: "2024-01-02", BasePrice: 1.1, GridStep: 50, AmountPerGrid: 1000, InitialCapital: 20000}
+	data, err := getSimulationData(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := calcSimulation(data, config)
+	if res.TotalTx != 0 {
+		t.Fatalf("%d trades, want none", res.TotalTx)
+	}
+	for _, s := range res.DailyStats {
+		if s.Equity != config.InitialCapital {
+			t.Fatalf("equity on %s: got %.3f, want %.3f", s.Date, s.Equity, config.InitialCapital)
+		}
+	}
+	if res.PeriodReturn != 0 || res.TotalYieldAmount != 0 {
+		t.Errorf("periodReturn %.3f%%, totalYieldAmount %.3f; want 0", res.PeriodReturn, res.TotalYieldAmount)
+	}
+}
+
+// TestPortfolioMixedMarkets checks that portfolio legs share their cash pool in real
+// time order. The A-share bar at 09:35 Beijing time (01:35 UTC) comes before the crypto
+// bar stamped 02:00 UTC, though its timestamp sorts after it; the pool only has cash
+// for the first of the two buys.
+func TestPortfolioMixedMarkets(t *testing.T) {
+	legs := []SimConfig{
+		{Symbol: "512890", BasePrice: 1, GridStep: 5, AmountPerGrid: 1000, InitialCapital: 750},
+		{Symbol: "BTCUSDT", BasePrice: 100, GridStep: 5, AmountPerGrid: 10, InitialCapital: 750},
+	}
+	data := []SimData{
+		{PreClose: 1, Source: resolveMarket("512890"), Klines: []Kline{
+			{Timestamp: "2024-01-02 09:35", Open: 1, High: 1, Low: 0.94, Close: 0.95},
+		}},
+		{PreClose: 100, Source: resolveMarket("BTCUSDT"), Klines: []Kline{
+			{Timestamp: "2024-01-02 02:00", Open: 100, High: 100, Low: 94, Close: 95},
+		}},
+	}
+	res := calcPortfolio(data, legs, 1500)
+	if got := res.Legs[0].Result.TotalTx; got != 1 {
+		t.Errorf("512890: %d trades, want 1 (the earlier buy)", got)
+	}
+	if got := res.Legs[1].Result.MissedBuys; got != 1 {
+		t.Errorf("BTCUSDT: %d missed buys, want 1 (the pool was used by 512890)", got)
+	}
+}
+
+// TestGridMatchAfterExDate checks that a sell still closes the lot bought at its grid
+// level after an ex-date has moved the grid, rather than falling back to the newest lot.
+func TestGridMatchAfterExDate(t *testing.T) {
+	config := SimConfig{Symbol: "512890", BasePrice: 1, GridStep: 5, AmountPerGrid: 1000, AdjustGridOnExDate: true}
+	data := SimData{
+		PreClose: 1,
+		Source:   resolveMarket(config.Symbol),
+		Klines: []Kline{
+			{Timestamp: "2024-01-02 10:00", Open: 1, High: 1, Low: 0.94, Close: 0.95},       // Buys at 0.95
+			{Timestamp: "2024-01-03 10:00", Open: 0.87, High: 0.87, Low: 0.87, Close: 0.87}, // Ex-date: the grid moves to base 0.9
+			{Timestamp: "2024-01-03 10:05", Open: 0.87, High: 0.91, Low: 0.87, Close: 0.9},  // Sells the lot at 0.9
+		},
+		Actions: []CorporateAction{{Symbol: config.Symbol, ExDate: "2024-01-03", CashPerShare: 0.1}},
+	}
+	e, err := newRun(data, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.feed(data.Klines[0], data)
+	e.feed(data.Klines[1], data)
+
+	g := e.strategy.(*gridStrategy)
+	level := g.matchLevel(g.lastExecIndex)
+	if level != 0.85 {
+		t.Errorf("lot level after the ex-date: got %.3f, want 0.85", level)
+	}
+	order := e.lots.matchOrder(level)
+	if len(order) == 0 {
+		t.Fatal("no open lots after the buy")
+	}
+	if lot := e.lots.lots[order[0]]; lot.Initial || lot.Level != level {
+		t.Errorf("a sell from level %.3f matches the lot at level %.3f", level, lot.Level)
+	}
+
+	e.feed(data.Klines[2], data)
+	if e.lots.total() != 0 || e.roundTrips != 1 {
+		t.Errorf("after the sell: %.0f shares in lots, %d round trips; want 0 and 1", e.lots.total(), e.roundTrips)
+	}
+}
+
+// TestSettlementBlockedOnce retries a T+1-blocked sell on 20 bars of one session: the
+// grid keeps the level, but the blocked sell counts once.
+func TestSettlementBlockedOnce(t *testing.T) {
+	config := SimConfig{Symbol: "512890", BasePrice: 1, GridStep: 5, AmountPerGrid: 1000, Settlement: "T+1"}
+	data := SimData{PreClose: 1, Source: resolveMarket(config.Symbol), Klines: []Kline{
+		{Timestamp: "2024-01-02 09:35", Open: 1, High: 1, Low: 0.94, Close: 0.95}, // Buys at 0.95
+	}}
+	for i := 0; i < 20; i++ {
+		ts := fmt.Sprintf("2024-01-02 %02d:%02d", 10+i/12, i%12*5)
+		data.Klines = append(data.Klines, Kline{Timestamp: ts, Open: 0.95, High: 1.01, Low: 0.95, Close: 0.95})
+	}
+	data.Klines = append(data.Klines, Kline{Timestamp: "2024-01-03 09:35", Open: 0.95, High: 1.01, Low: 0.95, Close: 1})
+
+	res := calcSimulation(data, config)
+	if res.SettlementBlockedSells != 1 {
+		t.Errorf("settlementBlockedSells: got %d, want 1", res.SettlementBlockedSells)
+	}
+	if res.TotalTx != 2 {
+		t.Errorf("%d trades, want 2 (the buy and the next session's sell)", res.TotalTx)
+	}
+}
+
+// TestLimitBlockedOnce sends a buy on each of 20 bars of a session locked at limit-up:
+// every one is refused, but it is one blocked buy and one event.
+func TestLimitBlockedOnce(t *testing.T) {
+	config := SimConfig{Symbol: "512890", BasePrice: 1, GridStep: 5, AmountPerGrid: 1000}
+	data := SimData{PreClose: 1, Source: resolveMarket(config.Symbol), Limits: map[string]PriceLimit{
+		"2024-01-02": {Up: 1.1, Down: 0.9},
+	}}
+	e, err := newRun(data, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		ts := fmt.Sprintf("2024-01-02 %02d:%02d", 10+i/12, i%12*5)
+		e.feed(Kline{Timestamp: ts, Open: 1.1, High: 1.1, Low: 1.1, Close: 1.1}, data)
+		if status := e.buy(Order{Side: "BUY", Price: 1.1, Amount: 1000}); status != OrderLimitLocked {
+			t.Fatalf("bar %d: order status %v, want OrderLimitLocked", i, status)
+		}
+	}
+	if e.result.LimitBlockedBuys != 1 || len(e.result.BlockedEvents) != 1 {
+		t.Errorf("limitBlockedBuys %d, %d blocked events; want 1 and 1", e.result.LimitBlockedBuys, len(e.result.BlockedEvents))
+	}
+}
+
+// TestSizingValidation checks that sizes the strategy cannot use are rejected rather
+// than replaced: a swept multiplier of 0 used to run as 2 under its own label.
+func TestSizingValidation(t *testing.T) {
+	base := SimConfig{Symbol: "512890", BasePrice: 1.1, GridStep: 1, AmountPerGrid: 100}
+	cases := []struct {
+		mode       string
+		multiplier float64
+		table      []float64
+		ok         bool
+	}{
+		{"multiplier", 1.5, nil, true},
+		{"multiplier", 0, nil, false},
+		{"multiplier", -1, nil, false},
+		{"table", 0, []float64{100, 200}, true},
+		{"table", 0, []float64{100, 0}, false},
+		{"table", 0, []float64{-100}, false},
+	}
+	for _, tc := range cases {
+		config := base
+		config.SizingMode, config.SizingMultiplier, config.SizingTable = tc.mode, tc.multiplier, tc.table
+		if err := validateSimConfig(config); (err == nil) != tc.ok {
+			t.Errorf("%s %v %v: got error %v, want ok %v", tc.mode, tc.multiplier, tc.table, err, tc.ok)
+		}
+	}
+}
+
+// TestUnknownBenchmark checks that a benchmark without stored bars fails validation
+// (400) instead of the data load (500).
+func TestUnknownBenchmark(t *testing.T) {
+	openTestDB(t)
+	config := SimConfig{Symbol: "512890", BasePrice: 1.1, GridStep: 1, AmountPerGrid: 100}
+	for symbol, ok := range map[string]bool{"": true, "512890": true, "00700": true, "NOPE": false} {
+		config.BenchmarkSymbol = symbol
+		if err := validateSimConfig(config); (err == nil) != ok {
+			t.Errorf("benchmark %q: got error %v, want ok %v", symbol, err, ok)
+		}
+	}
+}
+
+// TestTrailingLadders checks which ladders each trailing mode accepts: shift assumes
+// evenly spaced levels, and finite ladders cannot re-center at all.
+func TestTrailingLadders(t *testing.T) {
+	base := SimConfig{Symbol: "512890", BasePrice: 1.1, GridStep: 1, AmountPerGrid: 100}
+	cases := []struct {
+		mode, ladder string
+		ok           bool
+	}{
+		{"shift", "", true},
+		{"shift", "geometric", true},
+		{"shift", "dense", false},
+		{"ma", "dense", true},
+		{"range", "dense", true},
+		{"shift", "explicit", false},
+		{"ma", "log", false},
+	}
+	for _, tc := range cases {
+		config := base
+		config.TrailingMode, config.LadderType = tc.mode, tc.ladder
+		config.LadderLevels, config.LadderMin, config.LadderMax, config.LadderCount = []float64{1, 1.2}, 1, 1.2, 3
+		if err := validateSimConfig(config); (err == nil) != tc.ok {
+			t.Errorf("trailing %s on ladder %q: got error %v, want ok %v", tc.mode, tc.ladder, err, tc.ok)
+		}
+	}
+}
+
+// TestMissingBasePrice checks that a grid without a base price is rejected up front.
+// Its levels all sat at zero, and the run never returned.
+func TestMissingBasePrice(t *testing.T) {
+	cases := []struct {
+		config SimConfig
+		ok     bool
+	}{
+		{SimConfig{Symbol: "512890", GridStep: 1, AmountPerGrid: 100}, false},
+		{SimConfig{Symbol: "512890", GridStep: 1, AmountPerGrid: 100, BasePrice: -1}, false},
+		{SimConfig{Symbol: "512890", GridStep: 1, AmountPerGrid: 100, LadderType: "geometric"}, false},
+		{SimConfig{Symbol: "512890", GridStep: 1, AmountPerGrid: 100, BasePrice: 1.1}, true},
+		// A log or explicit ladder sets its own levels
+		{SimConfig{Symbol: "512890", AmountPerGrid: 100, LadderType: "explicit", LadderLevels: []float64{1, 1.1}}, true},
+	}
+	for _, tc := range cases {
+		if err := validateSimConfig(tc.config); (err == nil) != tc.ok {
+			t.Errorf("basePrice %v, ladder %q: got error %v, want ok %v", tc.config.BasePrice, tc.config.LadderType, err, tc.ok)
+		}
+	}
+}
+
+// openTestDB points DB at a fresh in-memory database holding the fixtures in testdata:
+// one CSV per kline table and corporate_actions.csv.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to ":memory:" opens its own empty database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	prev := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = prev
+		sqlDB.Close()
+	})
+
+	if err := DB.AutoMigrate(&Symbol{}, &CorporateAction{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"klines_1m", "klines_5m", "klines_daily", "hk_klines_1m", "hk_klines_5m", "hk_klines_daily"} {
+		if err := DB.Table(table).AutoMigrate(&Kline{}); err != nil {
+			t.Fatal(err)
+		}
+		rows := readFixture(t, table+".csv")
+		for _, r := range rows {
+			k := Kline{Symbol: r[0], Timestamp: r[1]}
+			k.Open, k.High, k.Low, k.Close = parseFloat(t, r[2]), parseFloat(t, r[3]), parseFloat(t, r[4]), parseFloat(t, r[5])
+			k.Volume = int64(parseFloat(t, r[6]))
+			if err := DB.Table(table).Create(&k).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, r := range readFixture(t, "corporate_actions.csv") {
+		a := CorporateAction{Symbol: r[0], ExDate: r[1], CashPerShare: parseFloat(t, r[2]), SplitRatio: parseFloat(t, r[3])}
+		if err := DB.Create(&a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFixture returns the rows of testdata/name without the header, none if the file does not exist.
+func readFixture(t *testing.T, name string) [][]string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[1:]
+}
+
+func parseFloat(t *testing.T, s string) float64 {
+	t.Helper()
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// firstDiff returns the first line (1-based) where want and got differ and both lines,
+// or 0 when they are equal.
+func firstDiff(want, got string) (int, string, string) {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			return i + 1, wl, gl
+		}
+	}
+	return 0, "", ""
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Order is an instruction emitted by a Strategy. The engine applies slippage,
+// commission and the capital / inventory checks before filling it.
+type Order struct {
+	Side       string  // "BUY" | "SELL"
+	Price      float64 // Trigger price before slippage (the grid level)
+	Amount     float64 // Shares
+	MatchLevel float64 // SELL only: grid level of the buy this sell closes, used by "grid" lot matching
+	Reason     string  // Recorded on the trade; "" for ordinary grid orders
+}
+
+func (o Order) reason() string {
+	if o.Reason == "" {
+		return "grid"
+	}
+	return o.Reason
+}
+
+// Portfolio is the read-only view of the account a strategy sees on each bar.
+// It is kept current by the engine, so it reflects fills made earlier in the same bar.
+type Portfolio struct {
+	Cash          float64 // Running cash balance (starts at InitialCapital, 0 = unlimited)
+	Position      float64 // Net shares bought by the strategy (excluding InitialShares)
+	InitialShares float64 // Base position held before the run
+}
+
+// Holdings returns the total number of shares currently held.
+func (p *Portfolio) Holdings() float64 {
+	return p.InitialShares + p.Position
+}
+
+// OrderStatus is the outcome of submitting an Order.
+type OrderStatus int
+
+const (
+	OrderFilled       OrderStatus = iota
+	OrderNoCash                   // BUY would exceed the capital limit
+	OrderNoInventory              // SELL exceeds the shares held
+	OrderUnsettled                // SELL exceeds the shares settled for this session (T+1)
+	OrderLimitLocked              // The bar is locked at the daily limit against this side
+	OrderInvalid                  // Non-positive price or amount
+	OrderOutOfRange               // The price is outside SimConfig's UpperBound / LowerBound
+	OrderBelowMinimum             // Nothing tradable is left after rounding to the lot rules
+	OrderPartial                  // The bar's volume only allowed part of it (possibly none); the rest is resting
+	OrderInactive                 // Placed after the last fill too recently to be working yet (SimConfig.OrderDelayBars)
+)
+
+// Broker executes orders for a strategy and reports what happened to each one,
+// together with the shares actually filled (the amount may be rounded to the lot rules).
+type Broker interface {
+	Submit(o Order) (OrderStatus, float64)
+}
+
+// Strategy receives every bar in chronological order and emits orders through the broker.
+// bar.Path is the intra-bar price path chosen by SimConfig.PathModel.
+type Strategy interface {
+	OnBar(bar Bar, pf *Portfolio, broker Broker)
+}
+
+type strategyFactory func(config SimConfig) Strategy
+
+var strategyRegistry = map[string]strategyFactory{
+	"grid": newGridStrategy,
+}
+
+const defaultStrategy = "grid"
+
+var trailingModes = map[string]bool{
+	"":      true, // Fixed base price
+	"ma":    true, // Follow a moving average of closes
+	"range": true, // Re-center after TrailingBars consecutive closes outside the range
+	"shift": true, // Shift by one level each time the top or bottom level is crossed
+}
+
+var sizingModes = map[string]bool{
+	"":           true, // Flat AmountPerGrid on every level
+	"multiplier": true, // Martingale: AmountPerGrid * SizingMultiplier^(depth-1) below base
+	"table":      true, // Explicit per-level sizes below base from SizingTable
+}
+
+func newStrategy(config SimConfig) (Strategy, error) {
+	name := config.Strategy
+	if name == "" {
+		name = defaultStrategy
+	}
+	factory, ok := strategyRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy: %s", name)
+	}
+	if !isPathModel(config.PathModel) {
+		return nil, fmt.Errorf("unknown path model: %s", config.PathModel)
+	}
+	if !trailingModes[config.TrailingMode] {
+		return nil, fmt.Errorf("unknown trailing mode: %s", config.TrailingMode)
+	}
+	if !sizingModes[config.SizingMode] {
+		return nil, fmt.Errorf("unknown sizing mode: %s", config.SizingMode)
+	}
+	if config.SizingMode == "multiplier" && config.SizingMultiplier <= 0 {
+		return nil, fmt.Errorf("sizing mode multiplier requires a positive sizingMultiplier")
+	}
+	if config.SizingMode == "table" && len(config.SizingTable) == 0 {
+		return nil, fmt.Errorf("sizing mode table requires sizingTable")
+	}
+	for _, size := range config.SizingTable {
+		if size <= 0 {
+			return nil, fmt.Errorf("sizingTable entries must be positive")
+		}
+	}
+	if err := validateLadder(config); err != nil {
+		return nil, err
+	}
+	if config.BuyStep < 0 || config.SellStep < 0 || config.BuyAmount < 0 || config.SellAmount < 0 {
+		return nil, fmt.Errorf("buy/sell steps and amounts must not be negative")
+	}
+	if (config.BuyStep > 0 || config.SellStep > 0) && (config.LadderType == "log" || config.LadderType == "explicit") {
+		return nil, fmt.Errorf("buyStep and sellStep do not apply to a %s ladder", config.LadderType)
+	}
+	// A finite ladder has no level past its ends to re-center onto
+	if config.TrailingMode != "" && (config.LadderType == "log" || config.LadderType == "explicit") {
+		return nil, fmt.Errorf("trailing mode %s does not apply to a %s ladder", config.TrailingMode, config.LadderType)
+	}
+	// "shift" moves the grid by one level and keeps the index of every other level one
+	// lower, which only holds for evenly spaced ladders; the gaps of a dense ladder
+	// depend on the distance to the base
+	if config.TrailingMode == "shift" && config.LadderType == "dense" {
+		return nil, fmt.Errorf("trailing mode shift does not apply to a dense ladder")
+	}
+	return factory(config), nil
+}
+
+func strategyNames() []string {
+	names := make([]string, 0, len(strategyRegistry))
+	for name := range strategyRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recenterer is implemented by strategies that move their grid center during a run.
+type recenterer interface {
+	RecenterEvents() []RecenterEvent
+}
+
+// corporateActionAware is implemented by strategies that react to ex-dates.
+// shiftLevels reports whether the run asked for price levels to follow the ex-rights price.
+type corporateActionAware interface {
+	OnCorporateAction(a CorporateAction, shiftLevels bool)
+}
+
+// gridStrategy is the classic symmetric grid: one level below the last fill is a buy,
+// one level above is a sell, levels are evenly spaced from the base price.
+// With a TrailingMode set, the base price follows the market instead of staying fixed.
+type gridStrategy struct {
+	config        SimConfig
+	ladder        Ladder // Levels around basePrice, rebuilt whenever the base moves
+	basePrice     float64
+	lastExecIndex int
+	started       bool
+
+	// Grid buys still held, most recent (deepest) last. The grid walks one level
+	// at a time, so the next sell always unwinds the top of this stack.
+	lots []gridLot
+
+	// Trailing state
+	maWindow   []float64
+	maSum      float64
+	outOfRange int
+	events     []RecenterEvent
+}
+
+// gridLot is a grid buy still (partly) held: the level it was bought at and how many
+// of its SellAmount-sized pieces have been sold.
+type gridLot struct {
+	idx     int     // Ladder index of level under the current base
+	level   float64 // Buy level price, used to find idx again when the base moves
+	amount  float64
+	sells   int
+	resting string // Side of the lot's order still resting in the engine ("" = none)
+}
+
+func newGridStrategy(config SimConfig) Strategy {
+	// Buys walk a ladder spaced by BuyStep; sells are placed relative to each lot
+	if config.BuyStep > 0 {
+		config.GridStep = config.BuyStep
+	}
+	g := &gridStrategy{config: config}
+	g.setBase(config.BasePrice)
+	return g
+}
+
+func (g *gridStrategy) setBase(base float64) {
+	g.basePrice = base
+	g.ladder = newLadder(g.config, base)
+	for i := range g.lots {
+		g.lots[i].idx = g.nearestIndex(g.lots[i].level)
+	}
+}
+
+func (g *gridStrategy) levelPrice(idx int) float64 {
+	return g.ladder.Level(idx)
+}
+
+// indexOf returns the grid index nearest price on the base side for the current base.
+func (g *gridStrategy) indexOf(price float64) int {
+	return g.ladder.IndexOf(price)
+}
+
+// nearestIndex returns the grid index whose level is closest to price.
+func (g *gridStrategy) nearestIndex(price float64) int {
+	best := g.indexOf(price)
+	for _, idx := range []int{best - 1, best + 1} {
+		if math.Abs(g.levelPrice(idx)-price) < math.Abs(g.levelPrice(best)-price) {
+			best = idx
+		}
+	}
+	return best
+}
+
+// amountAt returns the buy size for grid index idx. Levels at or above base use
+// AmountPerGrid; levels below base follow the sizing schedule.
+func (g *gridStrategy) amountAt(idx int) float64 {
+	unit := g.config.AmountPerGrid
+	if g.config.BuyAmount > 0 {
+		unit = g.config.BuyAmount
+	}
+	depth := -idx
+	if depth <= 0 {
+		return unit
+	}
+
+	switch g.config.SizingMode {
+	case "multiplier":
+		return unit * math.Pow(g.config.SizingMultiplier, float64(depth-1))
+	case "table":
+		// Levels deeper than the table keep the last size
+		if depth > len(g.config.SizingTable) {
+			return g.config.SizingTable[len(g.config.SizingTable)-1]
+		}
+		return g.config.SizingTable[depth-1]
+	}
+	return unit
+}
+
+// shares converts a configured size to shares at price; with SizingUnit "value" sizes
+// are currency amounts.
+func (g *gridStrategy) shares(amount, price float64) float64 {
+	if g.config.SizingUnit == "value" && price > 0 {
+		return amount / price
+	}
+	return amount
+}
+
+// sellPrice is where the n-th piece of a lot bought at level idx is sold: one sell step
+// above the previous piece. Without a SellStep a sell step is one ladder level.
+func (g *gridStrategy) sellPrice(idx, n int) float64 {
+	if g.config.SellStep <= 0 {
+		return g.levelPrice(idx + 1 + n)
+	}
+	p := g.levelPrice(idx)
+	for i := 0; i <= n; i++ {
+		if g.config.GridStepType == "absolute" {
+			p += g.config.SellStep
+		} else {
+			p *= 1 + g.config.SellStep/100
+		}
+	}
+	if g.config.SnapToTick {
+		if tick := symbolTick(g.config.Symbol, p); tick > 0 {
+			p = math.Round(p/tick) * tick
+		}
+	}
+	return RoundTo3(p)
+}
+
+// stepPrice is the distance between the base and the adjacent level above it
+// (below it at the top of a finite ladder).
+func (g *gridStrategy) stepPrice() float64 {
+	if up := g.levelPrice(1); !math.IsInf(up, 0) {
+		return up - g.levelPrice(0)
+	}
+	return g.levelPrice(0) - g.levelPrice(-1)
+}
+
+func (g *gridStrategy) OnBar(bar Bar, pf *Portfolio, broker Broker) {
+	if !g.started {
+		g.lastExecIndex = g.indexOf(bar.Open)
+		g.started = true
+	}
+
+	for _, leg := range bar.Path {
+		if leg.Side == "B" {
+			g.buyPass(leg.Price, broker)
+		} else {
+			g.sellPass(leg.Price, broker)
+		}
+	}
+
+	if g.config.TrailingMode != "" {
+		g.trail(bar.Kline)
+	}
+}
+
+// buyPass walks the grid down while low reaches the next buy level.
+func (g *gridStrategy) buyPass(low float64, broker Broker) {
+	for {
+		nextBuyIndex := g.lastExecIndex - 1
+		nextBuyPrice := g.levelPrice(nextBuyIndex)
+
+		triggered := false
+		if g.config.UsePenetration {
+			triggered = RoundTo3(low) < nextBuyPrice-0.00001
+		} else {
+			triggered = RoundTo3(low) <= nextBuyPrice+0.00001
+		}
+		if !triggered {
+			return
+		}
+
+		amount := g.shares(g.amountAt(nextBuyIndex), nextBuyPrice)
+		status, filled := broker.Submit(Order{Side: "BUY", Price: nextBuyPrice, Amount: amount})
+		if status != OrderFilled && status != OrderPartial {
+			return
+		}
+		g.lastExecIndex = nextBuyIndex
+		lot := gridLot{idx: nextBuyIndex, level: nextBuyPrice, amount: filled}
+		if status == OrderPartial {
+			// The bar's volume is used up; the rest of the buy rests at this level
+			lot.resting = "BUY"
+			g.lots = append(g.lots, lot)
+			return
+		}
+		g.lots = append(g.lots, lot)
+	}
+}
+
+// sellPass walks the grid up while high reaches the next sell level: the target of
+// the deepest held lot, or one sell step above the last fill when only base position is left.
+func (g *gridStrategy) sellPass(high float64, broker Broker) {
+	for {
+		// A symmetric grid sells one level above the last fill; with its own SellStep
+		// every lot is sold relative to the level it was bought at
+		lotIdx := g.lastExecIndex
+		sells := 0
+		if len(g.lots) > 0 {
+			top := g.lots[len(g.lots)-1]
+			if top.resting != "" {
+				// Part of the lot's order is still resting; selling now could sell it twice
+				return
+			}
+			sells = top.sells
+			if g.config.SellStep > 0 {
+				lotIdx = top.idx
+			}
+		}
+		nextSellPrice := g.sellPrice(lotIdx, sells)
+
+		triggered := false
+		if g.config.UsePenetration {
+			triggered = RoundTo3(high) > nextSellPrice+0.00001
+		} else {
+			triggered = RoundTo3(high) >= nextSellPrice-0.00001
+		}
+		if !triggered {
+			return
+		}
+
+		// Unwind the deepest lot; without one we are selling base position
+		amount := g.shares(g.amountAt(lotIdx), nextSellPrice)
+		if len(g.lots) > 0 {
+			amount = g.lots[len(g.lots)-1].amount
+		}
+		if sellAmount := g.shares(g.config.SellAmount, nextSellPrice); sellAmount > 0 && sellAmount < amount {
+			amount = sellAmount
+		}
+
+		status, filled := broker.Submit(Order{
+			Side:       "SELL",
+			Price:      nextSellPrice,
+			Amount:     amount,
+			MatchLevel: g.matchLevel(lotIdx),
+		})
+		switch status {
+		case OrderPartial:
+			// The rest of the sell rests at this price; the lot waits for it
+			if len(g.lots) == 0 {
+				g.afterSell(lotIdx, filled, nextSellPrice, true)
+				return
+			}
+			top := &g.lots[len(g.lots)-1]
+			top.amount -= filled
+			top.resting = "SELL"
+			return
+		case OrderFilled, OrderNoInventory, OrderBelowMinimum:
+			// Without a sellable quantity nothing is sold, but the grid still moves up
+			g.afterSell(lotIdx, filled, nextSellPrice, status == OrderFilled)
+		default:
+			// Shares exist but cannot be sold now (unsettled or limit-locked): keep the level and retry later
+			return
+		}
+	}
+}
+
+// matchLevel is the buy level a sell from lotIdx closes: the level the deepest lot was
+// bought at, which a re-centered or ex-rights ladder need not have at its index any more.
+func (g *gridStrategy) matchLevel(lotIdx int) float64 {
+	if len(g.lots) > 0 {
+		return g.lots[len(g.lots)-1].level
+	}
+	return g.levelPrice(lotIdx)
+}
+
+// afterSell moves the grid after a sell at price. A fully sold lot re-arms the buy at
+// its own level; a partly sold one waits one more sell step up.
+func (g *gridStrategy) afterSell(lotIdx int, amount, price float64, filled bool) {
+	if len(g.lots) == 0 {
+		next := lotIdx + 1
+		if g.config.SellStep > 0 {
+			// Base position sells follow the sell step, which need not land on a buy level
+			if idx := g.indexOf(price); idx > next {
+				next = idx
+			}
+		}
+		g.lastExecIndex = next
+		return
+	}
+	top := &g.lots[len(g.lots)-1]
+	if filled {
+		top.amount -= amount
+	}
+	if filled && top.amount > 0.0001 {
+		top.sells++
+		return
+	}
+	g.lots = g.lots[:len(g.lots)-1]
+	g.lastExecIndex = lotIdx + 1
+}
+
+// OnResting implements restingAware. Fills of a resting buy add to its lot and fills of
+// a resting sell take from the lot it sells. A lot left empty is dropped, re-arming its
+// buy level when it was the deepest.
+func (g *gridStrategy) OnResting(o Order, filled, left float64) {
+	for i := len(g.lots) - 1; i >= 0; i-- {
+		l := &g.lots[i]
+		if l.resting != o.Side || (o.Side == "BUY" && l.level != o.Price) {
+			continue
+		}
+		if o.Side == "BUY" {
+			l.amount += filled
+		} else {
+			l.amount -= filled
+		}
+		if left > 0 {
+			return
+		}
+		l.resting = ""
+		if l.amount > 0.0001 {
+			if o.Side == "SELL" && filled > 0 {
+				// A sell piece completed: the rest of the lot sells one more step up
+				l.sells++
+			}
+			return
+		}
+		if i == len(g.lots)-1 {
+			g.lastExecIndex = l.idx + 1
+		}
+		g.lots = append(g.lots[:i], g.lots[i+1:]...)
+		return
+	}
+}
+
+// trail applies the configured re-centering rule after the bar has been traded,
+// so a new base takes effect from the next bar.
+func (g *gridStrategy) trail(k Kline) {
+	rangeLevels := g.config.TrailingRangeLevels
+	if rangeLevels <= 0 {
+		rangeLevels = 5
+	}
+
+	switch g.config.TrailingMode {
+	case "ma":
+		period := g.config.TrailingMAPeriod
+		if period <= 0 {
+			period = 240
+		}
+		g.maWindow = append(g.maWindow, k.Close)
+		g.maSum += k.Close
+		if len(g.maWindow) > period {
+			g.maSum -= g.maWindow[0]
+			g.maWindow = g.maWindow[1:]
+		}
+		if len(g.maWindow) < period {
+			return
+		}
+		ma := g.maSum / float64(period)
+		// Only move once the average has drifted a full level, otherwise the grid would jitter every bar
+		if math.Abs(ma-g.basePrice) >= g.stepPrice() {
+			g.recenter(k, "ma", RoundTo3(ma), k.Close)
+		}
+
+	case "range":
+		bars := g.config.TrailingBars
+		if bars <= 0 {
+			bars = 30
+		}
+		if k.Close > g.levelPrice(rangeLevels) || k.Close < g.levelPrice(-rangeLevels) {
+			g.outOfRange++
+		} else {
+			g.outOfRange = 0
+		}
+		if g.outOfRange >= bars {
+			g.recenter(k, "range", RoundTo3(k.Close), k.Close)
+			g.outOfRange = 0
+		}
+
+	case "shift":
+		// Each crossing of the top (bottom) level moves the whole grid up (down) by one level
+		for g.lastExecIndex >= rangeLevels {
+			g.shift(k, 1)
+		}
+		for g.lastExecIndex <= -rangeLevels {
+			g.shift(k, -1)
+		}
+	}
+}
+
+func (g *gridStrategy) recenter(k Kline, reason string, newBase, refPrice float64) {
+	g.events = append(g.events, RecenterEvent{
+		Time:    k.Timestamp,
+		Reason:  reason,
+		OldBase: g.basePrice,
+		NewBase: newBase,
+		Price:   RoundTo3(k.Close),
+	})
+	g.setBase(newBase)
+	g.lastExecIndex = g.indexOf(refPrice)
+}
+
+// shift re-centers the grid on its level dir steps away. The ladder is rebuilt at the
+// new base and the last fill keeps its level, now one index closer to the base;
+// newStrategy only allows shift on ladders spaced alike around every base.
+func (g *gridStrategy) shift(k Kline, dir int) {
+	newBase := g.levelPrice(dir)
+	g.events = append(g.events, RecenterEvent{
+		Time:    k.Timestamp,
+		Reason:  "shift",
+		OldBase: g.basePrice,
+		NewBase: newBase,
+		Price:   RoundTo3(k.Close),
+	})
+	g.setBase(newBase)
+	g.lastExecIndex -= dir
+}
+
+// RecenterEvents implements recenterer.
+func (g *gridStrategy) RecenterEvents() []RecenterEvent {
+	return g.events
+}
+
+// Reenter implements reenterer: the grid restarts around the close of k.
+func (g *gridStrategy) Reenter(k Kline) {
+	g.recenter(k, "reenter", RoundTo3(k.Close), k.Close)
+}
+
+// OnCorporateAction implements corporateActionAware. Held lot sizes always follow a
+// split; the base price only moves to the ex-rights price when shiftLevels is set.
+func (g *gridStrategy) OnCorporateAction(a CorporateAction, shiftLevels bool) {
+	for i := range g.lots {
+		g.lots[i].amount *= a.ratio()
+		if shiftLevels {
+			g.lots[i].level = a.exRightsPrice(g.lots[i].level)
+		}
+	}
+	if shiftLevels {
+		g.setBase(a.exRightsPrice(g.basePrice))
+	}
+}
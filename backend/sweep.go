@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SweepConfig is a grid search: every combination of the axis values is run on top
+// of the embedded SimConfig.
+type SweepConfig struct {
+	SimConfig
+
+	Axes      []SweepAxis `json:"axes"`
+	Objective string      `json:"objective"` // Ranking, see objectives
+	Top       int         `json:"top"`       // Length of Ranking (0 = every combination)
+}
+
+// SweepAxis is one swept field: the listed Values, or Min to Max in Step increments.
+type SweepAxis struct {
+	Field  string    `json:"field"` // See sweepFields
+	Values []float64 `json:"values"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Step   float64   `json:"step"`
+}
+
+// maxSweepCombinations caps the simulations one sweep may run.
+const maxSweepCombinations = 10000
+
+// sweepFields are the SimConfig fields a sweep can vary, keyed by their JSON name.
+var sweepFields = map[string]func(*SimConfig, float64){
+	"gridStep":       func(c *SimConfig, v float64) { c.GridStep = v },
+	"amountPerGrid":  func(c *SimConfig, v float64) { c.AmountPerGrid = v },
+	"basePrice":      func(c *SimConfig, v float64) { c.BasePrice = v },
+	"slippageRate":   func(c *SimConfig, v float64) { c.SlippageRate = v },
+	"usePenetration": func(c *SimConfig, v float64) { c.UsePenetration = v != 0 }, // 0 | 1
+	"initialShares":  func(c *SimConfig, v float64) { c.InitialShares = int64(math.Round(v)) },
+	"maxVolumePct":   func(c *SimConfig, v float64) { c.MaxVolumePct = v },
+	"orderDelayBars": func(c *SimConfig, v float64) { c.OrderDelayBars = int(math.Round(v)) },
+}
+
+// sweepPositive are the sweep fields whose every value must be positive.
+var sweepPositive = map[string]bool{"gridStep": true, "amountPerGrid": true, "basePrice": true}
+
+// SweepCell is the outcome of one combination.
+type SweepCell struct {
+	Params       []float64 `json:"params"` // Value of each axis, in Axes order
+	Score        float64   `json:"score"`
+	TotalProfit  float64   `json:"totalProfit"`
+	GridProfit   float64   `json:"gridProfit"`
+	PeriodReturn float64   `json:"periodReturn"`
+	MaxDrawdown  float64   `json:"maxDrawdown"`
+	SharpeRatio  float64   `json:"sharpeRatio"`
+	CAGR         float64   `json:"cagr"`
+	TotalTx      int       `json:"totalTx"`
+	MissedBuys   int       `json:"missedBuys"`
+	MissedSells  int       `json:"missedSells"`
+}
+
+type SweepAxisValues struct {
+	Field  string    `json:"field"`
+	Values []float64 `json:"values"`
+}
+
+// SweepResult is the result tensor: Cells holds one entry per combination in
+// row-major order (the last axis varies fastest), so Shape reshapes it for a heatmap.
+type SweepResult struct {
+	Objective string            `json:"objective"`
+	Axes      []SweepAxisValues `json:"axes"`
+	Shape     []int             `json:"shape"`
+	Cells     []SweepCell       `json:"cells"`
+	Ranking   []int             `json:"ranking"` // Indices into Cells, best score first
+}
+
+// values resolves the axis to its list of values.
+func (a SweepAxis) values() ([]float64, error) {
+	if _, ok := sweepFields[a.Field]; !ok {
+		return nil, fmt.Errorf("field %q cannot be swept", a.Field)
+	}
+	values := a.Values
+	if len(values) == 0 {
+		// Values are rounded to 3 decimals, so a smaller step would repeat them
+		if a.Step < 0.001 || a.Max < a.Min {
+			return nil, fmt.Errorf("axis %s needs values or min <= max and a step of at least 0.001", a.Field)
+		}
+		if (a.Max-a.Min)/a.Step >= maxSweepCombinations {
+			return nil, fmt.Errorf("axis %s has more than %d values", a.Field, maxSweepCombinations)
+		}
+		for v := a.Min; v <= a.Max+1e-9; v = RoundTo3(v + a.Step) {
+			values = append(values, RoundTo3(v))
+		}
+	}
+	if sweepPositive[a.Field] {
+		for _, v := range values {
+			if v <= 0 {
+				return nil, fmt.Errorf("axis %s values must be positive", a.Field)
+			}
+		}
+	}
+	return values, nil
+}
+
+func runSweep(c *gin.Context) {
+	var config SweepConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config.Symbol == "" {
+		config.Symbol = "512890"
+	}
+	if config.GridStep <= 0 {
+		config.GridStep = 1.0
+	}
+	if config.AmountPerGrid <= 0 {
+		config.AmountPerGrid = 100
+	}
+	if len(config.Axes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one axis is required"})
+		return
+	}
+	if err := validateObjective(config.Objective); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	axes := make([]SweepAxisValues, len(config.Axes))
+	total := 1
+	for i, a := range config.Axes {
+		values, err := a.values()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		axes[i] = SweepAxisValues{Field: a.Field, Values: values}
+		total *= len(values)
+		if total > maxSweepCombinations {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("more than %d combinations", maxSweepCombinations)})
+			return
+		}
+	}
+
+	configs := sweepConfigs(config.SimConfig, axes)
+	for _, conf := range configs {
+		if err := validateSimConfig(conf); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	data, err := getSimulationData(config.SimConfig)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, calcSweep(data, config, axes, configs))
+}
+
+// sweepConfigs expands the axes into one config per combination, last axis fastest.
+func sweepConfigs(base SimConfig, axes []SweepAxisValues) []SimConfig {
+	configs := []SimConfig{base}
+	for _, axis := range axes {
+		set := sweepFields[axis.Field]
+		next := make([]SimConfig, 0, len(configs)*len(axis.Values))
+		for _, conf := range configs {
+			for _, v := range axis.Values {
+				c := conf
+				set(&c, v)
+				next = append(next, c)
+			}
+		}
+		configs = next
+	}
+	return configs
+}
+
+func calcSweep(data SimData, config SweepConfig, axes []SweepAxisValues, configs []SimConfig) SweepResult {
+	result := SweepResult{Objective: config.Objective, Axes: axes}
+	if result.Objective == "" {
+		result.Objective = "sharpe"
+	}
+	for _, a := range axes {
+		result.Shape = append(result.Shape, len(a.Values))
+	}
+
+	result.Cells = make([]SweepCell, len(configs))
+	runParallel(len(configs), func(i int) {
+		res := calcSimulation(data, configs[i])
+		result.Cells[i] = SweepCell{
+			Score:        objectiveScore(res, result.Objective),
+			TotalProfit:  res.TotalYieldAmount,
+			GridProfit:   res.TotalProfit,
+			PeriodReturn: res.PeriodReturn,
+			MaxDrawdown:  res.MaxDrawdown,
+			SharpeRatio:  res.SharpeRatio,
+			CAGR:         res.CAGR,
+			TotalTx:      res.TotalTx,
+			MissedBuys:   res.MissedBuys,
+			MissedSells:  res.MissedSells,
+		}
+	})
+
+	// Params follow from the cell's position in the tensor
+	for i := range result.Cells {
+		params := make([]float64, len(axes))
+		rest := i
+		for a := len(axes) - 1; a >= 0; a-- {
+			params[a] = axes[a].Values[rest%len(axes[a].Values)]
+			rest /= len(axes[a].Values)
+		}
+		result.Cells[i].Params = params
+	}
+
+	result.Ranking = rankCells(result.Cells)
+	if config.Top > 0 && config.Top < len(result.Ranking) {
+		result.Ranking = result.Ranking[:config.Top]
+	}
+	return result
+}
+
+// rankCells returns the indices of cells by score, best first. A NaN score (a ratio
+// of a run without variance) compares false both ways, so it is placed last explicitly.
+func rankCells(cells []SweepCell) []int {
+	ranking := make([]int, len(cells))
+	for i := range ranking {
+		ranking[i] = i
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		a, b := cells[ranking[i]].Score, cells[ranking[j]].Score
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return !math.IsNaN(a) && math.IsNaN(b)
+		}
+		return a > b
+	})
+	return ranking
+}
+
+// runParallel calls fn(0) .. fn(n-1) on one worker per CPU and waits for all of them.
+// Simulations share their SimData read-only, so runs on the same data are safe.
+func runParallel(n int, fn func(i int)) {
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSweepAxisValues(t *testing.T) {
+	cases := []struct {
+		axis SweepAxis
+		want []float64 // nil: rejected
+	}{
+		{SweepAxis{Field: "gridStep", Min: 0.1, Max: 0.3, Step: 0.1}, []float64{0.1, 0.2, 0.3}},
+		{SweepAxis{Field: "gridStep", Min: 0.1, Max: 0.2, Step: 0.0004}, nil},
+		{SweepAxis{Field: "gridStep", Min: 0.1, Max: 0.2, Step: 0}, nil},
+		{SweepAxis{Field: "slippageRate", Min: 0, Max: 0.002, Step: 0.001}, []float64{0, 0.001, 0.002}},
+		{SweepAxis{Field: "basePrice", Values: []float64{1.1, 0}}, nil},
+		{SweepAxis{Field: "commissionRate", Values: []float64{0.001}}, nil},
+	}
+	for _, tc := range cases {
+		got, err := tc.axis.values()
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%+v: got %v, want an error", tc.axis, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v: got %v, %v; want %v", tc.axis, got, err, tc.want)
+		}
+	}
+}
+
+func TestRankCellsNaNLast(t *testing.T) {
+	cells := []SweepCell{{Score: 1}, {Score: math.NaN()}, {Score: 3}, {Score: math.NaN()}, {Score: -2}}
+	if got, want := rankCells(cells), []int{2, 0, 4, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ranking %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WalkForwardConfig picks the grid parameters on a train window, trades them on the
+// test window that follows and moves forward one test window at a time.
+type WalkForwardConfig struct {
+	SimConfig // The run every window shares; GridStep and AmountPerGrid are searched
+
+	TrainDays int    `json:"trainDays"` // Trading days in a train window
+	TestDays  int    `json:"testDays"`  // Trading days in a test window, also the step between windows
+	Anchored  bool   `json:"anchored"`  // Every train window starts on the first day and grows (default: rolling)
+	Objective string `json:"objective"` // See objectives
+
+	// Candidates searched on every train window
+	MinStep      float64   `json:"minStep"`
+	MaxStep      float64   `json:"maxStep"`
+	StepInterval float64   `json:"stepInterval"`
+	Amounts      []float64 `json:"amounts"` // AmountPerGrid candidates (default: just AmountPerGrid)
+}
+
+// maxWalkForwardRuns caps the simulations one request may run (windows x candidates).
+const maxWalkForwardRuns = 5000
+
+// objectives lists the supported ways to rank parameter candidates; higher is better.
+var objectives = map[string]bool{
+	"":       true, // Same as "sharpe"
+	"sharpe": true, // SharpeRatio
+	"profit": true, // TotalYieldAmount
+	"calmar": true, // CAGR / MaxDrawdown (CAGR alone without a drawdown)
+}
+
+func validateObjective(objective string) error {
+	if !objectives[objective] {
+		return fmt.Errorf("unknown objective: %s", objective)
+	}
+	return nil
+}
+
+func objectiveScore(res SimResult, objective string) float64 {
+	switch objective {
+	case "profit":
+		return res.TotalYieldAmount
+	case "calmar":
+		if res.MaxDrawdown == 0 {
+			return res.CAGR
+		}
+		return res.CalmarRatio
+	}
+	return res.SharpeRatio
+}
+
+type WalkForwardWindow struct {
+	TrainStart      string  `json:"trainStart"`
+	TrainEnd        string  `json:"trainEnd"`
+	TestStart       string  `json:"testStart"`
+	TestEnd         string  `json:"testEnd"`
+	GridStep        float64 `json:"gridStep"` // Best candidate on the train window
+	AmountPerGrid   float64 `json:"amountPerGrid"`
+	TrainScore      float64 `json:"trainScore"` // Objective in-sample
+	TestScore       float64 `json:"testScore"`  // Objective out-of-sample
+	TestReturn      float64 `json:"testReturn"` // %
+	TestProfit      float64 `json:"testProfit"`
+	TestMaxDrawdown float64 `json:"testMaxDrawdown"`
+	TestTx          int     `json:"testTx"`
+}
+
+type WalkForwardPoint struct {
+	Date   string  `json:"date"`
+	Equity float64 `json:"equity"`
+	Window int     `json:"window"` // Index into Windows
+}
+
+type WalkForwardResult struct {
+	Objective   string              `json:"objective"`
+	TotalReturn float64             `json:"totalReturn"` // %, of the stitched out-of-sample curve
+	MaxDrawdown float64             `json:"maxDrawdown"` // %
+	Efficiency  float64             `json:"efficiency"`  // Mean test score / mean train score (0 when the mean train score is not positive)
+	Windows     []WalkForwardWindow `json:"windows"`
+	Equity      []WalkForwardPoint  `json:"equity"`
+}
+
+func runWalkForward(c *gin.Context) {
+	var config WalkForwardConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config.Symbol == "" {
+		config.Symbol = "512890"
+	}
+	if config.AmountPerGrid <= 0 {
+		config.AmountPerGrid = 100
+	}
+	if config.TrainDays <= 0 || config.TestDays <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "trainDays and testDays must be positive"})
+		return
+	}
+	steps, ok := stepCount(config.MinStep, config.MaxStep, config.StepInterval)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step parameters"})
+		return
+	}
+	if err := validateObjective(config.Objective); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	amounts := config.Amounts
+	if len(amounts) == 0 {
+		amounts = []float64{config.AmountPerGrid}
+	}
+	for _, a := range amounts {
+		if a <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amounts must be positive"})
+			return
+		}
+	}
+	// Every window runs each candidate once more than the test run, so at least this many
+	count := steps * float64(len(amounts))
+	if count+1 > maxWalkForwardRuns {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%.0f candidates is over the limit of %d runs", count, maxWalkForwardRuns)})
+		return
+	}
+
+	data, err := getSimulationData(config.SimConfig)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	days := data.tradingDays()
+	if len(days) <= config.TrainDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%d trading days of data, need more than trainDays", len(days))})
+		return
+	}
+	windows := (len(days) - config.TrainDays + config.TestDays - 1) / config.TestDays
+	if float64(windows)*(count+1) > maxWalkForwardRuns {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%d windows x %.0f candidates is over the limit of %d runs", windows, count, maxWalkForwardRuns)})
+		return
+	}
+
+	var candidates []SimConfig
+	for step := config.MinStep; step <= config.MaxStep+1e-9; step = RoundTo3(step + config.StepInterval) {
+		for _, amount := range amounts {
+			conf := config.SimConfig
+			conf.GridStep = RoundTo3(step)
+			conf.AmountPerGrid = amount
+			candidates = append(candidates, conf)
+		}
+	}
+	// Without a BasePrice every window centers on its own pre-close, so check with one
+	probe := candidates[0]
+	if probe.BasePrice <= 0 {
+		probe.BasePrice = data.PreClose
+	}
+	if err := validateSimConfig(probe); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, calcWalkForward(data, days, config, candidates))
+}
+
+// stepCount returns how many steps min, min+interval, ... up to max there are. Steps
+// are rounded to 3 decimals, so an interval below 0.001 would never advance; ok is false
+// for that and for a range that is not positive.
+func stepCount(min, max, interval float64) (float64, bool) {
+	if min <= 0 || max < min || interval < 0.001 {
+		return 0, false
+	}
+	return math.Floor((max-min)/interval+1e-9) + 1, true
+}
+
+// tradingDays returns the distinct trading days of d's bars in order.
+func (d SimData) tradingDays() []string {
+	var days []string
+	for _, k := range d.Klines {
+		if date := d.Source.SessionDate(k.Timestamp); len(days) == 0 || days[len(days)-1] != date {
+			days = append(days, date)
+		}
+	}
+	return days
+}
+
+// between returns the part of d dated from through to, with the last close before
+// from as its pre-close.
+func (d SimData) between(from, to string) SimData {
+	out := SimData{PreClose: d.PreClose, SubBars: d.SubBars, Limits: d.Limits, Benchmark: d.Benchmark, Source: d.Source}
+	for _, k := range d.Klines {
+		date := d.Source.SessionDate(k.Timestamp)
+		if date < from {
+			out.PreClose = k.Close
+			continue
+		}
+		if date > to {
+			break
+		}
+		out.Klines = append(out.Klines, k)
+	}
+	for _, h := range d.Halts {
+		if h.Date >= from && h.Date <= to {
+			out.Halts = append(out.Halts, h)
+		}
+	}
+	for _, a := range d.Actions {
+		if a.ExDate >= from && a.ExDate <= to {
+			out.Actions = append(out.Actions, a)
+		}
+	}
+	return out
+}
+
+// calcWalkForward runs every window: each candidate on the train days, then the best
+// one on the test days. Every window starts from the configured account, and without
+// a BasePrice its grid is centered on the close before the window. The out-of-sample
+// curve chains the test windows' returns.
+func calcWalkForward(data SimData, days []string, config WalkForwardConfig, candidates []SimConfig) WalkForwardResult {
+	result := WalkForwardResult{Objective: config.Objective}
+	if result.Objective == "" {
+		result.Objective = "sharpe"
+	}
+
+	initial := config.InitialCapital
+	equity := initial
+	peak, maxDrawdown := 0.0, 0.0
+	var trainScores, testScores []float64
+	for testStart := config.TrainDays; testStart < len(days); testStart += config.TestDays {
+		trainFrom := 0
+		if !config.Anchored {
+			trainFrom = testStart - config.TrainDays
+		}
+		testEnd := testStart + config.TestDays - 1
+		if testEnd >= len(days) {
+			testEnd = len(days) - 1
+		}
+		w := WalkForwardWindow{
+			TrainStart: days[trainFrom],
+			TrainEnd:   days[testStart-1],
+			TestStart:  days[testStart],
+			TestEnd:    days[testEnd],
+		}
+
+		train := data.between(w.TrainStart, w.TrainEnd)
+		scores := make([]float64, len(candidates))
+		runParallel(len(candidates), func(i int) {
+			conf := candidates[i]
+			if config.BasePrice <= 0 {
+				conf.BasePrice = train.PreClose
+			}
+			scores[i] = objectiveScore(calcSimulation(train, conf), result.Objective)
+		})
+		best := candidates[0]
+		w.TrainScore = math.Inf(-1)
+		for i, score := range scores {
+			if score > w.TrainScore {
+				best, w.TrainScore = candidates[i], score
+			}
+		}
+
+		test := data.between(w.TestStart, w.TestEnd)
+		if config.BasePrice <= 0 {
+			best.BasePrice = test.PreClose
+		}
+		res := calcSimulation(test, best)
+		w.GridStep = best.GridStep
+		w.AmountPerGrid = best.AmountPerGrid
+		w.TestScore = objectiveScore(res, result.Objective)
+		w.TestReturn = res.PeriodReturn
+		w.TestProfit = res.TotalYieldAmount
+		w.TestMaxDrawdown = res.MaxDrawdown
+		w.TestTx = res.TotalTx
+		trainScores = append(trainScores, w.TrainScore)
+		testScores = append(testScores, w.TestScore)
+
+		// Scale the window's own equity curve onto the end of the previous one
+		if initial <= 0 {
+			// Without an InitialCapital the curve starts at the first window's capital
+			initial, equity = res.Capital, res.Capital
+		}
+		start, windowEquity := equity, res.Capital
+		peak = math.Max(peak, start)
+		for _, s := range res.DailyStats {
+			windowEquity += s.NetProfit
+			if res.Capital > 0 {
+				equity = start * windowEquity / res.Capital
+			}
+			peak = math.Max(peak, equity)
+			if peak > 0 {
+				maxDrawdown = math.Max(maxDrawdown, (peak-equity)/peak)
+			}
+			result.Equity = append(result.Equity, WalkForwardPoint{Date: s.Date, Equity: RoundTo3(equity), Window: len(result.Windows)})
+		}
+		result.Windows = append(result.Windows, w)
+	}
+
+	if initial > 0 {
+		result.TotalReturn = RoundTo3((equity/initial - 1) * 100)
+	}
+	result.MaxDrawdown = RoundTo3(maxDrawdown * 100)
+	// A ratio to a non-positive train score has no meaning, and its sign flips
+	if meanTrain := Mean(trainScores); meanTrain > 0 {
+		result.Efficiency = RoundTo3(Mean(testScores) / meanTrain)
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DateRange is an inclusive range of dates ("2006-01-02").
+type DateRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func validateWindow(config SimConfig) error {
+	for _, d := range append([]string{config.StartDate, config.EndDate}, config.ExcludeDates...) {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", d)
+		}
+	}
+	if config.EndDate != "" && config.EndDate < config.StartDate {
+		return fmt.Errorf("endDate is before startDate")
+	}
+	for _, r := range config.DateRanges {
+		if _, err := time.Parse("2006-01-02", r.Start); err != nil {
+			return fmt.Errorf("invalid date range start %q", r.Start)
+		}
+		if _, err := time.Parse("2006-01-02", r.End); err != nil {
+			return fmt.Errorf("invalid date range end %q", r.End)
+		}
+		if r.End < r.Start {
+			return fmt.Errorf("date range %s..%s ends before it starts", r.Start, r.End)
+		}
+	}
+	return nil
+}
+
+// windowStart is the first date the window can trade: StartDate, moved up to the
+// earliest DateRange when every range starts later.
+func windowStart(config SimConfig) string {
+	if len(config.DateRanges) == 0 {
+		return config.StartDate
+	}
+	first := config.DateRanges[0].Start
+	for _, r := range config.DateRanges {
+		if r.Start < first {
+			first = r.Start
+		}
+	}
+	if first > config.StartDate {
+		return first
+	}
+	return config.StartDate
+}
+
+// windowEnd is the exclusive timestamp bound of the window, "" when it is open-ended.
+func windowEnd(config SimConfig) string {
+	if config.EndDate == "" {
+		return ""
+	}
+	t, _ := time.Parse("2006-01-02", config.EndDate)
+	return t.AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// inWindow reports whether the run trades on date.
+func inWindow(config SimConfig, date string) bool {
+	if date < config.StartDate || (config.EndDate != "" && date > config.EndDate) {
+		return false
+	}
+	for _, d := range config.ExcludeDates {
+		if d == date {
+			return false
+		}
+	}
+	if len(config.DateRanges) == 0 {
+		return true
+	}
+	for _, r := range config.DateRanges {
+		if date >= r.Start && date <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// barQuery selects the bars of symbol in table from start up to the exclusive end
+// ("" = no end).
+func barQuery(table, symbol, start, end string) *gorm.DB {
+	q := DB.Table(table).Where("symbol = ? AND timestamp >= ?", symbol, start)
+	if end != "" {
+		q = q.Where("timestamp < ?", end)
+	}
+	return q
+}
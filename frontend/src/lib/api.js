@@ -67,6 +67,11 @@ export const runBatchSimulation = async (config) => {
     return response.data.data;
 };
 
+export const getStrategies = async () => {
+    const response = await api.get('/simulate/strategies');
+    return response.data.data;
+};
+
 export const getSymbols = async () => {
     const response = await api.get('/symbols');
     return response.data.data;